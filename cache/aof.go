@@ -0,0 +1,542 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const AofFileSUFFIX = "_aof.cdb"
+
+// AofFsync fsync policy of the append only file
+type AofFsync int
+
+const (
+	// FsyncEverySec fsync the append only file once per second
+	FsyncEverySec AofFsync = iota
+	// FsyncAlways fsync the append only file after every record
+	FsyncAlways
+	// FsyncNo never fsync, leave it to the operating system
+	FsyncNo
+)
+
+// aof record operation
+type aofOp uint8
+
+const (
+	aofSet aofOp = iota + 1
+	aofDelete
+	aofClear
+)
+
+// aofRecord a record of the append only file
+type aofRecord[K comparable, E any] struct {
+	Op         aofOp
+	Key        K
+	Object     E
+	Expiration int64
+	Tags       []string `json:",omitempty"`
+}
+
+// the file starts with: magic(4 bytes) version(1 byte) codec length(1 byte) codec name
+var aofMagic = []byte("GUCA")
+
+const aofVersion = 1
+
+var errAofTruncatedHeader = errors.New("truncated header")
+
+// every record is framed as: length(4 bytes) crc32(4 bytes) payload
+const aofFrameHeader = 8
+
+// encode the file header
+func encodeAofHeader(codec Codec) []byte {
+	name := codec.Name()
+	header := make([]byte, 0, len(aofMagic)+2+len(name))
+	header = append(header, aofMagic...)
+	header = append(header, aofVersion, byte(len(name)))
+	return append(header, name...)
+}
+
+// read the file header and find the codec of the records
+// A nil codec is returned for an empty file
+func readAofHeader(r *bufio.Reader, configured Codec) (codec Codec, size int, err error) {
+	magic, err := r.Peek(len(aofMagic))
+	if err == io.EOF && len(magic) == 0 {
+		return nil, 0, nil
+	}
+	if !bytes.Equal(magic, aofMagic) {
+		if bytes.HasPrefix(aofMagic, magic) {
+			return nil, 0, errAofTruncatedHeader
+		}
+		return nil, 0, errors.New("not an append only file")
+	}
+	header := make([]byte, len(aofMagic)+2)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, 0, errAofTruncatedHeader
+	}
+	if header[4] != aofVersion {
+		return nil, 0, fmt.Errorf("unknown version %d", header[4])
+	}
+	name := make([]byte, header[5])
+	_, err = io.ReadFull(r, name)
+	if err != nil {
+		return nil, 0, errAofTruncatedHeader
+	}
+	codec, err = findCodec(string(name), configured)
+	if err != nil {
+		return nil, 0, err
+	}
+	return codec, len(header) + len(name), nil
+}
+
+// encode a record as a frame
+func encodeAofRecord[K comparable, E any](codec Codec, record *aofRecord[K, E]) ([]byte, error) {
+	payload, err := codec.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return encodeFrame(payload), nil
+}
+
+// frame a payload: length(4 bytes) crc32(4 bytes) payload
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, aofFrameHeader, aofFrameHeader+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	return append(frame, payload...)
+}
+
+// write the file header and the records
+func writeAofRecords[K comparable, E any](w io.Writer, codec Codec, records []aofRecord[K, E]) error {
+	bw := bufio.NewWriter(w)
+	_, err := bw.Write(encodeAofHeader(codec))
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		frame, err := encodeAofRecord(codec, &records[i])
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(frame)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// read the next frame, io.EOF is returned at the end of the file
+func readAofFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, aofFrameHeader)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated record header")
+		}
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, errors.New("truncated record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// replay the append only file into items
+// A damaged tail (e.g. the process crashed in the middle of a write) is truncated
+// It reports whether the file must be rewritten, because it is not written with the configured codec
+func replayAof[K comparable, E any](file string, items map[K]*Item[E], configured Codec) (bool, error) {
+	f, err := os.OpenFile(file, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	codec, size, err := readAofHeader(r, configured)
+	if err != nil {
+		if err != errAofTruncatedHeader {
+			return false, err
+		}
+		log.Printf("cache: aof %s has a truncated header, truncate it", file)
+		return false, f.Truncate(0)
+	}
+	if codec == nil {
+		return false, nil
+	}
+	rewrite := codec.Name() != configured.Name()
+	offset := int64(size)
+	for {
+		payload, err := readAofFrame(r)
+		if err == io.EOF {
+			return rewrite, nil
+		}
+		if err == nil {
+			var record aofRecord[K, E]
+			err = codec.Unmarshal(payload, &record)
+			if err == nil {
+				applyAofRecord(items, &record)
+				offset += int64(aofFrameHeader + len(payload))
+				continue
+			}
+		}
+		log.Printf("cache: aof %s is damaged at offset %d (%v), truncate it", file, offset, err)
+		return rewrite, f.Truncate(offset)
+	}
+}
+
+// apply a record to items
+func applyAofRecord[K comparable, E any](items map[K]*Item[E], record *aofRecord[K, E]) {
+	switch record.Op {
+	case aofSet:
+		items[record.Key] = &Item[E]{
+			Object:     record.Object,
+			Expiration: record.Expiration,
+			tags:       record.Tags,
+		}
+	case aofDelete:
+		delete(items, record.Key)
+	case aofClear:
+		for k := range items {
+			delete(items, k)
+		}
+	}
+}
+
+// aofWriter append records to the append only file
+type aofWriter struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	codec Codec
+	fsync AofFsync
+	err   error // the first write error, the writer stops after it
+	stop  chan struct{}
+
+	size              int64         // current size of the file
+	baseSize          int64         // size of the file after the last rewrite
+	rewriteMinSize    int64         // auto rewrite when the file is bigger than it
+	rewritePercentage int64         // auto rewrite when the file grows by this percentage
+	rewriting         bool          // a rewrite is in progress
+	rewriteBuf        *bytes.Buffer // records appended during the rewrite
+}
+
+// open the append only file for appending
+func openAof(file string, o persistenceOption) (*aofWriter, error) {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() == 0 {
+		_, err = f.Write(encodeAofHeader(o.codec))
+		if err == nil {
+			info, err = f.Stat()
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	w := &aofWriter{
+		path:              file,
+		file:              f,
+		codec:             o.codec,
+		fsync:             o.aofFsync,
+		stop:              make(chan struct{}),
+		size:              info.Size(),
+		baseSize:          info.Size(),
+		rewriteMinSize:    o.aofRewriteMinSize,
+		rewritePercentage: int64(o.aofRewritePercentage),
+	}
+	if w.fsync == FsyncEverySec {
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// append a frame to the file
+// It returns true when the file has grown enough to be rewritten, the caller owns the rewrite then
+func (w *aofWriter) append(frame []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return false
+	}
+	_, err := w.file.Write(frame)
+	if err == nil && w.fsync == FsyncAlways {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		w.err = err
+		log.Printf("cache: write aof %s failed, stop appending: %v", w.path, err)
+		return false
+	}
+	w.size += int64(len(frame))
+	if w.rewriteBuf != nil {
+		w.rewriteBuf.Write(frame)
+	}
+	if w.rewriting || w.rewritePercentage <= 0 || w.size < w.rewriteMinSize {
+		return false
+	}
+	if w.baseSize > 0 && (w.size-w.baseSize)*100/w.baseSize < w.rewritePercentage {
+		return false
+	}
+	w.rewriting = true
+	return true
+}
+
+// claim the rewrite, it fails if a rewrite is in progress
+func (w *aofWriter) beginRewrite() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.rewriting {
+		return errors.New("AOF rewrite is in progress")
+	}
+	w.rewriting = true
+	return nil
+}
+
+// records appended from now on will be copied to the rewritten file
+// It must be called while the cache is locked, together with taking the snapshot
+func (w *aofWriter) captureRewrite() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rewriteBuf = new(bytes.Buffer)
+}
+
+// append the captured records to the rewritten file and swap it in
+func (w *aofWriter) finishRewrite(tmp *os.File) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer w.endRewrite()
+	if w.err != nil {
+		return w.err
+	}
+	_, err := tmp.Write(w.rewriteBuf.Bytes())
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), w.path)
+	if err != nil {
+		return err
+	}
+	_ = syncDir(filepath.Dir(w.path))
+	_ = w.file.Close()
+	w.file = tmp
+	w.size = info.Size()
+	w.baseSize = info.Size()
+	return nil
+}
+
+// release the rewrite, w.mu must be held
+func (w *aofWriter) endRewrite() {
+	w.rewriting = false
+	w.rewriteBuf = nil
+}
+
+// abort the rewrite
+func (w *aofWriter) abortRewrite() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.endRewrite()
+}
+
+// sync commit the file to stable storage
+func (w *aofWriter) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.file.Sync()
+}
+
+// close stop fsyncing and close the file, the records are fsynced for the last time
+func (w *aofWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.stop)
+	err := w.err
+	if err == nil {
+		err = w.file.Sync()
+	}
+	closeErr := w.file.Close()
+	w.err = ErrClosed
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// fsync once per second
+func (w *aofWriter) syncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = w.sync()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// append only file
+func (persistence *persistenceOption) aofFile() string {
+	return filepath.Join(persistence.persistencePath, fmt.Sprintf("%s%s", persistence.persistenceName, AofFileSUFFIX))
+}
+
+// start append only file persistence
+// The existing file is replayed first, then every change is appended to it
+func (c *mapCache[K, E]) startAof() error {
+	file := c.aofFile()
+	rewrite, err := replayAof(file, c.items, c.codec)
+	if err != nil {
+		return err
+	}
+	if rewrite {
+		// convert the file to the configured codec
+		err = c.writeAof(file)
+		if err != nil {
+			return err
+		}
+	}
+	c.aof, err = openAof(file, c.persistenceOption)
+	return err
+}
+
+// write the current data to the append only file atomically
+func (c *mapCache[K, E]) writeAof(file string) error {
+	tmp, err := os.OpenFile(file+tmpFileSUFFIX, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = writeAofRecords(tmp, c.codec, c.aofRecords())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(file))
+}
+
+// the minimal records to rebuild the current data, c.mu must be held
+func (c *mapCache[K, E]) aofRecords() []aofRecord[K, E] {
+	records := make([]aofRecord[K, E], 0, len(c.items))
+	for k, v := range c.items {
+		if v.expired() {
+			continue
+		}
+		records = append(records, aofRecord[K, E]{Op: aofSet, Key: k, Object: v.Object, Expiration: v.Expiration, Tags: v.tags})
+	}
+	return records
+}
+
+// append a record to the append only file if it is enabled
+func (c *mapCache[K, E]) appendAof(record *aofRecord[K, E]) {
+	if c.aof == nil {
+		return
+	}
+	frame, err := encodeAofRecord(c.aof.codec, record)
+	if err != nil {
+		log.Printf("cache: encode aof record %v failed: %v", record.Key, err)
+		return
+	}
+	if c.aof.append(frame) {
+		go func() {
+			err := c.rewriteAof()
+			if err != nil {
+				log.Printf("cache: rewrite aof %s failed: %v", c.aof.path, err)
+			}
+		}()
+	}
+}
+
+// RewriteAof rewrite the append only file now, it returns when the new file replaces the old one
+// The file is rebuilt with the minimal records of the current data, writers are not blocked while it is written
+func (c *mapCache[K, E]) RewriteAof() error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if c.aof == nil {
+		return errors.New("AOF is not enabled")
+	}
+	err := c.aof.beginRewrite()
+	if err != nil {
+		return err
+	}
+	return c.rewriteAof()
+}
+
+// rewrite the append only file, the caller must have claimed the rewrite
+func (c *mapCache[K, E]) rewriteAof() (err error) {
+	tmp, err := os.OpenFile(c.aof.path+".rewrite", os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		c.aof.abortRewrite()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			c.aof.abortRewrite()
+		}
+	}()
+
+	c.mu.RLock()
+	c.aof.captureRewrite()
+	records := c.aofRecords()
+	c.mu.RUnlock()
+
+	err = writeAofRecords(tmp, c.aof.codec, records)
+	if err != nil {
+		return err
+	}
+	return c.aof.finishRewrite(tmp)
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileBackendSUFFIX suffix of the files of FileBackend
+const FileBackendSUFFIX = "_l2.cdb"
+
+// fileEntry the content of a file of FileBackend
+type fileEntry[E any] struct {
+	Key        string
+	Object     E
+	Expiration int64
+}
+
+// FileBackend a Backend storing every data in its own file under a directory
+// The files are named by the hash of the key and written atomically, so the data survives restarts
+type FileBackend[E any] struct {
+	dir   string
+	codec Codec
+}
+
+// NewFileBackend create a FileBackend in the directory, the data is encoded by the codec, nil means GobCodec
+func NewFileBackend[E any](dir string, codec Codec) (*FileBackend[E], error) {
+	if codec == nil {
+		codec = GobCodec
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileBackend[E]{dir: dir, codec: codec}, nil
+}
+
+// file of the key, the files are spread over 256 sub directories
+func (b *FileBackend[E]) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(b.dir, name[:2], name+FileBackendSUFFIX)
+}
+
+// Get get data by key, the expired data is deleted
+func (b *FileBackend[E]) Get(ctx context.Context, key string) (E, bool, error) {
+	var zero E
+	if err := ctx.Err(); err != nil {
+		return zero, false, err
+	}
+	file := b.path(key)
+	entry, err := b.read(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return zero, false, nil
+		}
+		return zero, false, err
+	}
+	if entry.Key != key {
+		return zero, false, nil
+	}
+	if entry.expired() {
+		_ = os.Remove(file)
+		return zero, false, nil
+	}
+	return entry.Object, true, nil
+}
+
+// Set set data by key, it expires after ttl, 0 means it never expires
+func (b *FileBackend[E]) Set(ctx context.Context, key string, value E, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entry := fileEntry[E]{Key: key, Object: value}
+	if ttl > 0 {
+		entry.Expiration = time.Now().Add(ttl).UnixMicro()
+	}
+	data, err := b.codec.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	file := b.path(key)
+	dir := filepath.Dir(file)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	// concurrent writers of a key write their own temporary files
+	tmp, err := os.CreateTemp(dir, filepath.Base(file)+"*"+tmpFileSUFFIX)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Delete delete data by key, deleting data that does not exist is not an error
+func (b *FileBackend[E]) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := os.Remove(b.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteExpired delete the files of the expired data, and the files that cannot be decoded
+func (b *FileBackend[E]) DeleteExpired(ctx context.Context) error {
+	return filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, FileBackendSUFFIX) {
+			return nil
+		}
+		entry, err := b.read(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || entry.expired() {
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// read and decode a file
+func (b *FileBackend[E]) read(file string) (*fileEntry[E], error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entry fileEntry[E]
+	err = b.codec.Unmarshal(data, &entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (e *fileEntry[E]) expired() bool {
+	return e.Expiration != 0 && time.Now().UnixMicro() > e.Expiration
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+)
+
+// invalidation the message sent between the caches of Broadcaster
+type invalidation struct {
+	Origin string   `json:"origin"` // id of the sender, used to drop the echo of our own messages
+	Keys   []string `json:"keys,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// Broadcaster keep the caches of several processes consistent
+// The deletions and tag invalidations made through it are applied to the local cache and sent to the peers over the
+// transport, the ones received from the peers are applied to the local cache without being sent again
+type Broadcaster[E any] struct {
+	cache     MapInterface[E]
+	transport Transport
+	origin    string
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{}
+}
+
+// NewBroadcaster create a Broadcaster of the cache, it starts receiving from the transport at once
+// The transport is closed with the Broadcaster
+func NewBroadcaster[E any](cache MapInterface[E], transport Transport) (*Broadcaster[E], error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	b := &Broadcaster[E]{
+		cache:     cache,
+		transport: transport,
+		origin:    hex.EncodeToString(id),
+		done:      make(chan struct{}),
+	}
+	go b.receive()
+	return b, nil
+}
+
+// Delete  delete data by keys locally and in the peers
+// The local data is deleted even if sending fails
+func (b *Broadcaster[E]) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	b.cache.DeleteMany(keys)
+	return b.send(invalidation{Keys: keys})
+}
+
+// InvalidateTag  delete data with any of the tags locally and in the peers
+// The local data is deleted even if sending fails
+func (b *Broadcaster[E]) InvalidateTag(tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	b.invalidateTags(tags)
+	return b.send(invalidation{Tags: tags})
+}
+
+// Close  stop receiving and close the transport
+func (b *Broadcaster[E]) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errors.New("broadcaster is closed")
+	}
+	b.closed = true
+	b.mu.Unlock()
+	err := b.transport.Close()
+	<-b.done
+	return err
+}
+
+func (b *Broadcaster[E]) send(msg invalidation) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return errors.New("broadcaster is closed")
+	}
+	msg.Origin = b.origin
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.transport.Send(data)
+}
+
+// receive apply the invalidations of the peers until the transport is closed
+func (b *Broadcaster[E]) receive() {
+	defer close(b.done)
+	for {
+		data, err := b.transport.Receive()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if !closed {
+				log.Printf("cache: receive invalidation failed, stop receiving: %v", err)
+			}
+			return
+		}
+		var msg invalidation
+		if err = json.Unmarshal(data, &msg); err != nil {
+			log.Printf("cache: decode invalidation failed: %v", err)
+			continue
+		}
+		if msg.Origin == b.origin {
+			continue
+		}
+		if len(msg.Keys) > 0 {
+			b.cache.DeleteMany(msg.Keys)
+		}
+		b.invalidateTags(msg.Tags)
+	}
+}
+
+func (b *Broadcaster[E]) invalidateTags(tags []string) {
+	for _, tag := range tags {
+		b.cache.InvalidateTag(tag)
+	}
+}
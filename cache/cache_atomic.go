@@ -0,0 +1,111 @@
+package cache
+
+import "reflect"
+
+// Update  update data by key atomically
+// fn gets the current data and whether it exists, and returns the new data and whether to store it.
+// When the data exists its expiration time is kept, otherwise the default expiration time is used.
+// It returns the data in the cache afterwards and whether it exists. fn is called while holding the lock, so it must not use the cache
+func (c *mapCache[K, E]) Update(key K, fn func(old E, exists bool) (E, bool)) (E, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	var zero E
+	if c.closed {
+		return zero, false
+	}
+	c.judgeAndInitItem()
+	old, exists := c.get(key)
+	var oldValue E
+	if exists {
+		oldValue = old.Object
+	}
+	value, ok := fn(oldValue, exists)
+	if !ok {
+		return oldValue, exists
+	}
+	c.update(key, old, value)
+	return value, true
+}
+
+// CompareAndSwap  set data by key to new if it is old atomically, and return whether it is swapped
+// The expiration time of the data is kept
+func (c *mapCache[K, E]) CompareAndSwap(key K, old, new E) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	item, ok := c.get(key)
+	if !ok || !equal(item.Object, old) {
+		return false
+	}
+	c.update(key, item, new)
+	return true
+}
+
+// CompareAndDelete  delete data by key if it is old atomically, and return whether it is deleted
+func (c *mapCache[K, E]) CompareAndDelete(key K, old E) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	item, ok := c.get(key)
+	if !ok || !equal(item.Object, old) {
+		return false
+	}
+	c.del(key, ReasonDeleted)
+	return true
+}
+
+// GetOrSet  get data by key, and set it if it does not exist or expires
+// It returns the data in the cache, and true if it exists before
+func (c *mapCache[K, E]) GetOrSet(key K, value E) (E, bool) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return value, false
+	}
+	c.judgeAndInitItem()
+	item, ok := c.get(key)
+	c.stats.read(ok)
+	if ok {
+		c.touch(key, item)
+		c.access(key)
+		return item.Object, true
+	}
+	c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), nil)
+	return value, false
+}
+
+// Replace  set data by key only if it exists, and return whether it is set
+func (c *mapCache[K, E]) Replace(key K, value E) bool {
+	c.mu.Lock()
+	defer c.unlock()
+	if !c.contains(key) {
+		return false
+	}
+	c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), nil)
+	return true
+}
+
+// update the data keeping its expiration time, old is nil when the data does not exist, c.mu must be held
+func (c *mapCache[K, E]) update(key K, old *Item[E], value E) {
+	if old == nil {
+		c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), nil)
+		return
+	}
+	c.set(key, value, old.Expiration, old.idle, c.costOf(value), old.tags)
+}
+
+// judge whether two data are equal, with == if the type is comparable, otherwise with reflect.DeepEqual
+func equal[E any](a, b E) (res bool) {
+	x, y := any(a), any(b)
+	if x == nil || y == nil {
+		return x == y
+	}
+	if reflect.TypeOf(x).Comparable() && reflect.TypeOf(y).Comparable() {
+		// a comparable type may hold an uncomparable value in an interface field, == panics on it
+		defer func() {
+			if recover() != nil {
+				res = reflect.DeepEqual(x, y)
+			}
+		}()
+		return x == y
+	}
+	return reflect.DeepEqual(x, y)
+}
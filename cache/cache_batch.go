@@ -0,0 +1,63 @@
+package cache
+
+import "time"
+
+// GetMany  get data by keys under one lock
+// The data that does not exist or expires is not in the result
+func (c *mapCache[K, E]) GetMany(keys []K) map[K]E {
+	unlock, exclusive := c.readLock()
+	defer unlock()
+	res := make(map[K]E, len(keys))
+	for _, key := range keys {
+		value, ok := c.lookup(key)
+		c.stats.read(ok)
+		if !ok {
+			continue
+		}
+		if exclusive {
+			c.touch(key, value)
+		}
+		c.access(key)
+		c.refresh(key, value, c.loader)
+		res[key] = value.Object
+	}
+	return res
+}
+
+// SetMany  set data under one lock，it will overwrite the data if the key exists
+// The data expires after ttl. When ttl is 0 the default expiration time is used, when it is DefaultExpiration
+// the data never expires
+func (c *mapCache[K, E]) SetMany(items map[K]E, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	c.judgeAndInitItem()
+
+	for key, value := range items {
+		switch ttl {
+		case 0:
+			c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), nil)
+		case DefaultExpiration:
+			c.set(key, value, 0, 0, c.costOf(value), nil)
+		default:
+			c.set(key, value, c.generateExpirationForItem(ttl), 0, c.costOf(value), nil)
+		}
+	}
+}
+
+// DeleteMany  delete data by keys under one lock, and return the number of data deleted
+func (c *mapCache[K, E]) DeleteMany(keys []K) int {
+	c.mu.Lock()
+	defer c.unlock()
+	deleted := 0
+	for _, key := range keys {
+		c.forget(key)
+		if c.contains(key) {
+			c.del(key, ReasonDeleted)
+			deleted++
+		}
+	}
+	return deleted
+}
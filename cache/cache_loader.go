@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// ErrNoLoader neither a loader is given nor configured by SetLoader
+	ErrNoLoader = errors.New("no loader")
+	// ErrLoaderPanic the loader panicked, it is returned to the callers waiting for the same load,
+	// the panic is propagated to the caller running the loader
+	ErrLoaderPanic = errors.New("loader panicked")
+)
+
+// a load in progress
+type loadCall[E any] struct {
+	done  chan struct{}
+	value E
+	err   error
+	item  *Item[E] // the data reloaded in the background, nil when the data is missing
+	// the data is deleted while loading, the result is returned to the callers but not saved
+	forgotten bool
+}
+
+// a cached error of the loader
+type loadError struct {
+	err        error
+	expiration int64
+}
+
+func (e *loadError) expired() bool {
+	return time.Now().UnixMicro() > e.expiration
+}
+
+// GetOrLoad get data, and load it by the loader when it does not exist or expires
+// Without a loader, the loader configured by SetLoader is used. Concurrent misses on a key are coalesced into a
+// single load, the loaded data is set with the default expiration time and the error of the loader is returned
+func (c *mapCache[K, E]) GetOrLoad(key K, loader func(key K) (E, error)) (E, error) {
+	var zero E
+	if loader == nil {
+		loader = c.loader
+	}
+	if loader == nil {
+		return zero, ErrNoLoader
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.unlock()
+		return zero, ErrClosed
+	}
+	value, ok := c.lookup(key)
+	c.stats.read(ok)
+	if ok {
+		c.touch(key, value)
+		c.access(key)
+		c.refresh(key, value, loader)
+		c.unlock()
+		return value.Object, nil
+	}
+	if e, ok := c.negative[key]; ok && !e.expired() {
+		c.unlock()
+		return zero, e.err
+	}
+	if call, ok := c.loads[key]; ok {
+		c.unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &loadCall[E]{done: make(chan struct{})}
+	if c.loads == nil {
+		c.loads = make(map[K]*loadCall[E])
+	}
+	c.loads[key] = call
+	c.unlock()
+
+	c.load(key, call, loader)
+	return call.value, call.err
+}
+
+// call the loader and save the result, the waiting callers are woken up even if the loader panics
+func (c *mapCache[K, E]) load(key K, call *loadCall[E], loader func(key K) (E, error)) {
+	start := time.Now()
+	defer func() {
+		c.stats.load(call.err, time.Since(start))
+		c.mu.Lock()
+		if c.loads[key] == call {
+			delete(c.loads, key)
+		}
+		c.saveLoaded(key, call)
+		c.unlock()
+		close(call.done)
+	}()
+	call.err = ErrLoaderPanic
+	call.value, call.err = loader(key)
+}
+
+// save the result of a load, c.mu must be held
+// The data set while loading is newer, so it is kept
+func (c *mapCache[K, E]) saveLoaded(key K, call *loadCall[E]) {
+	if c.closed || call.forgotten {
+		return
+	}
+	if call.item != nil {
+		// the data set or deleted while reloading is newer, and the stale data is kept if the reload fails
+		if call.err == nil && c.items[key] == call.item {
+			c.set(key, call.value, c.generateExpiration(), c.generateIdle(), c.costOf(call.value), call.item.tags)
+		}
+		return
+	}
+	if call.err != nil {
+		// the load stopped by the context of the caller says nothing about the data
+		if c.negativeTTL > 0 && !errors.Is(call.err, context.Canceled) && !errors.Is(call.err, context.DeadlineExceeded) {
+			if c.negative == nil {
+				c.negative = make(map[K]*loadError)
+			}
+			c.negative[key] = &loadError{err: call.err, expiration: time.Now().Add(c.negativeTTL).UnixMicro()}
+		}
+		return
+	}
+	delete(c.negative, key)
+	if c.contains(key) {
+		return
+	}
+	c.judgeAndInitItem()
+	c.set(key, call.value, c.generateExpiration(), c.generateIdle(), c.costOf(call.value), nil)
+}
+
+// drop the cached error and the load in progress of the key, so the data deleted is not brought back by the
+// load, c.mu must be held
+func (c *mapCache[K, E]) forget(key K) {
+	delete(c.negative, key)
+	if call, ok := c.loads[key]; ok {
+		call.forgotten = true
+		delete(c.loads, key)
+	}
+}
+
+// judge whether the data is reloaded in the background when it is read
+func (c *mapCache[K, E]) refreshable() bool {
+	return c.loader != nil && (c.refreshAfter > 0 || c.staleGrace > 0)
+}
+
+// judge whether the data is expired but still served within the stale grace window
+func (c *mapCache[K, E]) stale(item *Item[E]) bool {
+	window := c.staleWindow()
+	if window == 0 || item.Expiration == 0 {
+		return false
+	}
+	return time.Now().UnixNano()/1e3 <= item.Expiration+window
+}
+
+// how long the expired data is still served in microseconds, 0 when it is not served
+func (c *mapCache[K, E]) staleWindow() int64 {
+	if c.loader == nil || c.staleGrace <= 0 {
+		return 0
+	}
+	return c.staleGrace.Microseconds()
+}
+
+// get the data to serve by key, the expired data is served within the stale grace window
+func (c *mapCache[K, E]) lookup(key K) (*Item[E], bool) {
+	value, ok := c.items[key]
+	if !ok || (value.expired() && !c.stale(value)) {
+		return nil, false
+	}
+	if value.cold && !c.restore(key, value) {
+		return nil, false
+	}
+	return value, true
+}
+
+// reload the data in the background if it is due, c.mu must be held for writing
+func (c *mapCache[K, E]) refresh(key K, item *Item[E], loader func(key K) (E, error)) {
+	if loader == nil || !c.refreshable() {
+		return
+	}
+	due := item.expired() ||
+		(c.refreshAfter > 0 && time.Now().UnixNano()/1e3 > item.written+c.refreshAfter.Microseconds())
+	if !due {
+		return
+	}
+	if _, ok := c.loads[key]; ok {
+		return
+	}
+	call := &loadCall[E]{done: make(chan struct{}), item: item}
+	if c.loads == nil {
+		c.loads = make(map[K]*loadCall[E])
+	}
+	c.loads[key] = call
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("cache: reload %v panicked: %v", key, r)
+			}
+		}()
+		c.load(key, call, loader)
+	}()
+}
@@ -3,61 +3,181 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrClosed the cache has been closed
+var ErrClosed = errors.New("cache is closed")
+
 type MapCache[E any] struct {
-	*mapCache[E]
+	*mapCache[string, E]
+}
+
+// KeyMapCache the keys can be of any comparable type
+type KeyMapCache[K comparable, E any] struct {
+	*mapCache[K, E]
 }
 
-type mapCache[E any] struct {
-	items  map[string]*Item[E] // Cache data items are stored in the map
-	mu     sync.RWMutex        // Read write lock
+type mapCache[K comparable, E any] struct {
+	items  map[K]*Item[E] // Cache data items are stored in the map
+	mu     sync.RWMutex   // Read write lock
 	stopGc chan bool
 	isGc   bool
+	closed bool
+
+	evictor   evictor[K]    // usage of the data, nil when the capacity is unlimited
+	valueCost func(E) int64 // cost function of the data
+	totalCost int64         // total cost of all data in memory
+	resident  int           // number of data in memory
+
+	disk *overflowStore[K, E] // the data spilled from memory, nil when Overflow is not enabled
+
+	onEvicted func(K, E, EvictReason) // called when the data leaves the cache
+	evicted   []evictedItem[K, E]     // data left the cache while holding the lock, reported by unlock
+
+	loader   func(K) (E, error) // load the data missing from the cache
+	loads    map[K]*loadCall[E] // loads in progress, concurrent misses on a key wait for the same load
+	negative map[K]*loadError   // cached errors of the loader
+
+	stats *statsCollector // statistics, nil when they are not enabled
+
+	expiry  expiryIndex[K] // expiration time of the data, gc deletes the data in this order
+	sliding int32          // 1 when there may be data with sliding expiration, accessed atomically
+
+	tags map[string]map[K]struct{} // keys of the data by tag
+
+	publisher *publisher[K, E] // subscribers of the changes
+	events    []Event[K, E]    // changes made while holding the lock, published by unlock
+
+	aof             *aofWriter    // append only file, nil when AOF is disabled
+	stopPersistence chan struct{} // stop the backup loop
+	persistenceDone chan struct{} // closed when the backup loop exits
+	snapshotMu      sync.Mutex    // only one snapshot is written at a time
+	dirty           int64         // number of changes since the last snapshot
+	lastSave        time.Time     // time of the last snapshot
 	options
 }
 
-// NewMapCache create a cache with mapCache
+// NewMapCache create a cache with mapCache, the keys are strings
 func NewMapCache[E any](opts ...CreateOptionFunc) (MapInterface[E], error) {
+	res, err := startMapCache[string, E](opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &MapCache[E]{
+		res,
+	}
+	runtime.SetFinalizer(c, func(m *MapCache[E]) {
+		_ = m.Close()
+	})
+	return c, nil
+}
+
+// NewKeyMapCache create a cache with mapCache, the keys can be of any comparable type
+// With persistence, the keys are encoded by the codec as well
+func NewKeyMapCache[K comparable, E any](opts ...CreateOptionFunc) (KeyMapInterface[K, E], error) {
+	res, err := startMapCache[K, E](opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &KeyMapCache[K, E]{
+		res,
+	}
+	runtime.SetFinalizer(c, func(m *KeyMapCache[K, E]) {
+		_ = m.Close()
+	})
+	return c, nil
+}
+
+// Close stop gc and persistence, and write the data to the persistence file for the last time
+// The wrapper is kept alive until it is closed, otherwise its finalizer could close it at the same time
+func (c *MapCache[E]) Close() error {
+	defer runtime.KeepAlive(c)
+	return c.mapCache.Close()
+}
+
+// Close stop gc and persistence, and write the data to the persistence file for the last time
+// The wrapper is kept alive until it is closed, otherwise its finalizer could close it at the same time
+func (c *KeyMapCache[K, E]) Close() error {
+	defer runtime.KeepAlive(c)
+	return c.mapCache.Close()
+}
+
+// create a mapCache with the options and start gc
+func startMapCache[K comparable, E any](opts []CreateOptionFunc) (*mapCache[K, E], error) {
 	exp := newOption()
 	for _, opt := range opts {
 		opt(&exp)
 	}
-	res := &mapCache[E]{
-		options: exp,
-		stopGc:  make(chan bool),
+	res, err := newMapCache[K, E](exp)
+	if err != nil {
+		return nil, err
 	}
-	if exp.expiration != DefaultExpiration {
+	if exp.expiration != DefaultExpiration || exp.slidingExpiration > 0 {
 		// start gc
 		_ = res.StartGc()
 	}
+	return res, nil
+}
+
+// create a mapCache and load the persistence file, gc is not started
+func newMapCache[K comparable, E any](exp options) (*mapCache[K, E], error) {
+	res := &mapCache[K, E]{
+		publisher: newPublisher[K, E](exp.eventBuffer, exp.eventOverflow),
+		options:   exp,
+	}
+	if exp.costFunc != nil {
+		costFunc, ok := exp.costFunc.(func(E) int64)
+		if !ok {
+			return nil, fmt.Errorf("the cost function %T does not match the type of the cache", exp.costFunc)
+		}
+		res.valueCost = costFunc
+	}
+	if exp.onEvicted != nil {
+		onEvicted, ok := exp.onEvicted.(func(K, E, EvictReason))
+		if !ok {
+			return nil, fmt.Errorf("the evicted function %T does not match the type of the cache", exp.onEvicted)
+		}
+		res.onEvicted = onEvicted
+	}
+	if exp.loader != nil {
+		loader, ok := exp.loader.(func(K) (E, error))
+		if !ok {
+			return nil, fmt.Errorf("the loader %T does not match the type of the cache", exp.loader)
+		}
+		res.loader = loader
+	}
+	if exp.enableStats {
+		res.stats = &statsCollector{}
+	}
 	if exp.enablePersistence {
-		res.items = make(map[string]*Item[E])
-		err := res.startPersistence(&(res.items))
+		res.items = make(map[K]*Item[E])
+		err := res.startPersistence()
 		if err != nil {
 			return nil, err
 		}
 	}
-	c := &MapCache[E]{
-		res,
+	res.initItems()
+	if exp.maxEntries > 0 || exp.maxCost > 0 {
+		res.mu.Lock()
+		res.initEviction()
+		res.unlock()
 	}
-	runtime.SetFinalizer(c, func(m *MapCache[E]) {
-		_ = m.StopGc()
-	})
-	return c, nil
+	return res, nil
 }
 
 // Expired cache data Item cleanup
-func (c *mapCache[E]) gcLoop() {
+func (c *mapCache[K, E]) gcLoop(stop chan bool) {
 	ticker := time.NewTicker(c.gcInterval)
 	for {
 		select {
 		case <-ticker.C:
 			c.DeleteExpired()
-		case <-c.stopGc:
+		case <-stop:
 			ticker.Stop()
 			return
 		}
@@ -65,201 +185,636 @@ func (c *mapCache[E]) gcLoop() {
 }
 
 // StopGc stop gc
-func (c *mapCache[E]) StopGc() error {
+func (c *mapCache[K, E]) StopGc() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.stopGcLocked()
+}
+
+// stop gc, c.mu must be held
+// The loop is stopped by closing the channel, so it never blocks even if the loop is waiting for the lock
+func (c *mapCache[K, E]) stopGcLocked() error {
 	if !c.isGc {
 		return errors.New("GC is closed")
 	}
 	c.isGc = false
-	c.stopGc <- true
+	close(c.stopGc)
 	return nil
 }
 
 // StartGc start gc
 // After the expiration time is set, GC will be started automatically without manual GC
-func (c *mapCache[E]) StartGc() error {
+func (c *mapCache[K, E]) StartGc() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
 	if c.isGc {
 		return errors.New("GC has been started")
 	}
 	c.isGc = true
-	go c.gcLoop()
+	c.stopGc = make(chan bool)
+	go c.gcLoop(c.stopGc)
 	return nil
 }
 
+// Flush write the data to the persistence file now
+// With FFB a snapshot is written, with AOF the file is fsynced
+func (c *mapCache[K, E]) Flush() error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	return c.flush()
+}
+
+// Close stop gc and persistence, and write the data to the persistence file for the last time
+// After closing, operations returning an error return ErrClosed, the others behave as if the cache is empty
+func (c *mapCache[K, E]) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	if c.isGc {
+		_ = c.stopGcLocked()
+	}
+	c.mu.Unlock()
+
+	err := c.stopPersistenceLoop()
+
+	c.mu.Lock()
+	c.items = nil
+	c.expiry.reset()
+	c.totalCost = 0
+	c.resident = 0
+	c.negative = nil
+	c.tags = nil
+	c.mu.Unlock()
+	c.publisher.close()
+	return err
+}
+
+// data left the cache
+type evictedItem[K comparable, E any] struct {
+	key    K
+	value  E
+	reason EvictReason
+}
+
+// record the data left the cache, it is reported after the lock is released
+func (c *mapCache[K, E]) notify(key K, item *Item[E], reason EvictReason) {
+	if c.onEvicted == nil {
+		return
+	}
+	if item.expired() && reason != ReasonCleared {
+		reason = ReasonExpired
+	}
+	value, ok := c.object(key, item)
+	if !ok {
+		return
+	}
+	c.evicted = append(c.evicted, evictedItem[K, E]{key: key, value: value, reason: reason})
+}
+
+// unlock c.mu, then report the data left the cache and publish the changes made while holding the lock
+func (c *mapCache[K, E]) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	events := c.events
+	c.events = nil
+	if len(events) > 0 {
+		c.publisher.publish(events, c.mu.Unlock)
+	} else {
+		c.mu.Unlock()
+	}
+	for _, e := range evicted {
+		c.onEvicted(e.key, e.value, e.reason)
+	}
+}
+
 // delete data by key
-func (c *mapCache[E]) del(key string) {
+func (c *mapCache[K, E]) del(key K, reason EvictReason) {
+	if c.remove(key, reason) {
+		c.stats.remove(reason, 1)
+	}
+}
+
+// delete data by key without counting it in the statistics, and return whether it exists
+func (c *mapCache[K, E]) remove(key K, reason EvictReason) bool {
+	item, ok := c.items[key]
+	if ok {
+		c.totalCost -= item.cost
+		if !item.cold {
+			c.resident--
+		}
+		c.untag(key, item.tags)
+		c.notify(key, item, reason)
+		var zero E
+		c.emit(removeOp(item, reason), key, item, zero)
+		if item.disk != nil {
+			c.disk.discard(item.disk)
+		}
+	}
 	delete(c.items, key)
+	c.expiry.remove(key)
+	if c.evictor != nil {
+		c.evictor.remove(key)
+	}
+	c.dirty++
+	c.appendAof(&aofRecord[K, E]{Op: aofDelete, Key: key})
+	return ok
 }
 
 // set cache data by key
-func (c *mapCache[E]) set(key string, value E, expiration int64) {
+func (c *mapCache[K, E]) set(key K, value E, expiration, idle int64, cost int64, tags []string) {
+	if old, ok := c.items[key]; ok {
+		c.notify(key, old, ReasonReplaced)
+	}
+	c.store(key, value, expiration, idle, cost, tags)
+}
+
+// store cache data by key without reporting the overwritten data
+func (c *mapCache[K, E]) store(key K, value E, expiration, idle int64, cost int64, tags []string) {
+	c.stats.set()
+	c.put(key, value, expiration, idle, cost, tags)
+}
+
+// store cache data by key without reporting the overwritten data or counting it in the statistics
+func (c *mapCache[K, E]) put(key K, value E, expiration, idle int64, cost int64, tags []string) {
+	old, exists := c.items[key]
+	c.emit(EventSet, key, old, value)
+	if exists {
+		c.totalCost -= old.cost
+		if !old.cold {
+			c.resident--
+		}
+		c.untag(key, old.tags)
+		if old.disk != nil {
+			c.disk.discard(old.disk)
+		}
+	}
 	c.items[key] = &Item[E]{
 		Object:     value,
 		Expiration: expiration,
+		cost:       cost,
+		written:    time.Now().UnixNano() / 1e3,
+		idle:       idle,
+		tags:       tags,
 	}
+	c.tag(key, tags)
+	c.resident++
+	if idle > 0 {
+		atomic.StoreInt32(&c.sliding, 1)
+	}
+	c.expiry.update(key, expiration)
+	c.totalCost += cost
+	c.dirty++
+	c.appendAof(&aofRecord[K, E]{Op: aofSet, Key: key, Object: value, Expiration: expiration, Tags: tags})
+	if c.maxCost > 0 && cost > c.maxCost {
+		// it never fits, so it is dropped at once instead of evicting all the other data in vain
+		c.del(key, ReasonEvicted)
+		return
+	}
+	if c.evictor != nil {
+		if exists {
+			c.evictor.access(key)
+		} else {
+			c.evictor.add(key)
+		}
+		c.evict()
+	}
+}
+
+// cost of the data
+func (c *mapCache[K, E]) costOf(value E) int64 {
+	if c.valueCost == nil {
+		return 1
+	}
+	return c.valueCost(value)
 }
 
-// get data by key
-func (c *mapCache[E]) get(key string) (*Item[E], bool) {
+// cost and index the data loaded from the persistence file
+func (c *mapCache[K, E]) initItems() {
+	for k, item := range c.items {
+		if !item.cold {
+			item.cost = c.costOf(item.Object)
+			c.totalCost += item.cost
+			c.resident++
+		}
+		c.expiry.update(k, item.Expiration)
+		c.tag(k, item.tags)
+	}
+}
+
+// init the usage of the data loaded from the persistence file, and evict the data beyond the capacity
+func (c *mapCache[K, E]) initEviction() {
+	c.evictor = newEvictor[K](c.evictionPolicy, c.maxEntries)
+	for k, item := range c.items {
+		if !item.cold {
+			c.evictor.add(k)
+		}
+	}
+	c.evict()
+}
+
+// judge whether the cache exceeds its capacity, only the data in memory counts
+func (c *mapCache[K, E]) overflow() bool {
+	return (c.maxEntries > 0 && c.resident > c.maxEntries) || (c.maxCost > 0 && c.totalCost > c.maxCost)
+}
+
+// evict the data chosen by the eviction policy until the cache fits its capacity
+// With the overflow store the data is spilled to it instead
+func (c *mapCache[K, E]) evict() {
+	for c.overflow() {
+		key, ok := c.evictor.victim()
+		if !ok {
+			return
+		}
+		if c.disk != nil {
+			c.spill(key)
+		} else {
+			c.del(key, ReasonEvicted)
+		}
+	}
+}
+
+// lock for reading and return the unlock function, and whether the write lock is taken
+// When the capacity is limited, reading updates the usage of the data, and with sliding expiration reading
+// extends the expiration time, so the write lock is taken
+func (c *mapCache[K, E]) readLock() (func(), bool) {
+	if c.evictor == nil && !c.refreshable() && atomic.LoadInt32(&c.sliding) == 0 {
+		c.mu.RLock()
+		return c.mu.RUnlock, false
+	}
+	c.mu.Lock()
+	return c.unlock, true
+}
+
+// extend the expiration time of the data with sliding expiration, c.mu must be held for writing
+func (c *mapCache[K, E]) touch(key K, item *Item[E]) {
+	if item.idle == 0 || item.expired() {
+		return
+	}
+	item.Expiration = time.Now().UnixNano()/1e3 + item.idle
+	c.expiry.update(key, item.Expiration)
+}
+
+// mark the data as recently used
+func (c *mapCache[K, E]) access(key K) {
+	if c.evictor != nil {
+		c.evictor.access(key)
+	}
+}
+
+// get data by key, the spilled data is read back into memory, c.mu must be held for writing
+func (c *mapCache[K, E]) get(key K) (*Item[E], bool) {
 	value, ok := c.items[key]
 	if !ok || value.expired() {
 		return nil, false
 	}
+	if value.cold && !c.restore(key, value) {
+		return nil, false
+	}
 	return value, true
 }
 
+// judge whether the data exists and is not expired, the spilled data is not read back
+func (c *mapCache[K, E]) contains(key K) bool {
+	value, ok := c.items[key]
+	return ok && !value.expired()
+}
+
 // generate expiration time
-func (c *mapCache[E]) generateExpiration() int64 {
+func (c *mapCache[K, E]) generateExpiration() int64 {
+	if c.slidingExpiration > 0 {
+		return time.Now().Add(c.slidingExpiration).UnixNano() / 1e3
+	}
 	if c.expiration == DefaultExpiration {
 		return 0
 	}
 	return time.Now().Add(c.expiration).UnixNano() / 1e3
 }
 
+// generate idle timeout of sliding expiration
+func (c *mapCache[K, E]) generateIdle() int64 {
+	return c.slidingExpiration.Microseconds()
+}
+
 // generate expiration time
-func (c *mapCache[E]) generateExpirationForItem(expiration time.Duration) int64 {
+func (c *mapCache[K, E]) generateExpirationForItem(expiration time.Duration) int64 {
 	return time.Now().Add(expiration).UnixNano() / 1e3
 }
 
 // init data
-func (c *mapCache[E]) judgeAndInitItem() {
+func (c *mapCache[K, E]) judgeAndInitItem() {
 	if c.items == nil {
-		c.items = make(map[string]*Item[E])
+		c.items = make(map[K]*Item[E])
 	}
 }
 
 // IsExpired judge whether the data is expired
-func (c *mapCache[E]) IsExpired(key string) (bool, error) {
+func (c *mapCache[K, E]) IsExpired(key K) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false, ErrClosed
+	}
 	value, ok := c.items[key]
 	if !ok {
-		return false, fmt.Errorf("the data %s does not exist", key)
+		return false, fmt.Errorf("the data %v does not exist", key)
 	}
 	return value.expired(), nil
 }
 
 // DeleteExpired delete all expired data
-func (c *mapCache[E]) DeleteExpired() {
+// The data is deleted in batches in the order of expiration, the lock is released between batches
+func (c *mapCache[K, E]) DeleteExpired() {
+	for c.deleteExpiredBatch() {
+	}
+}
+
+// delete a batch of expired data, and return whether there is more
+// A batch deletes at most gcBatchSize data items, and stops after gcBatchTime
+func (c *mapCache[K, E]) deleteExpiredBatch() bool {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	if c.closed {
+		return false
+	}
 
-	for k, v := range c.items {
+	start := time.Now()
+	// the expired data is kept within the stale grace window
+	deadline := start.UnixNano()/1e3 - c.staleWindow()
+	for i := 0; ; i++ {
+		key, expiration, ok := c.expiry.peek()
+		if !ok || expiration >= deadline {
+			break
+		}
+		if i == gcBatchSize || (i%64 == 63 && time.Since(start) > gcBatchTime) {
+			return true
+		}
+		c.del(key, ReasonExpired)
+	}
+	for k, v := range c.negative {
 		if v.expired() {
-			c.del(k)
+			delete(c.negative, k)
 		}
 	}
+	return false
 }
 
 // Delete delete data by key
-func (c *mapCache[E]) Delete(key string) (E, bool) {
+func (c *mapCache[K, E]) Delete(key K) (E, bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	value, ok := c.get(key)
-	if ok {
-		c.del(key)
-		return value.Object, ok
+	defer c.unlock()
+	c.forget(key)
+	if c.contains(key) {
+		value, ok := c.object(key, c.items[key])
+		c.del(key, ReasonDeleted)
+		return value, ok
 	}
 	var zero E
-	return zero, ok
+	return zero, false
 }
 
 // Set  data by key，it will overwrite the data if the key exists
-func (c *mapCache[E]) Set(key string, value E) {
+func (c *mapCache[K, E]) Set(key K, value E) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
 	c.judgeAndInitItem()
 
-	c.set(key, value, c.generateExpiration())
+	c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), nil)
 }
 
 // SetDefault  data by key，it will overwrite the data if the key exists
-func (c *mapCache[E]) SetDefault(key string, value E, expiration time.Duration) {
+func (c *mapCache[K, E]) SetDefault(key K, value E, expiration time.Duration) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	c.judgeAndInitItem()
+
+	c.set(key, value, c.generateExpirationForItem(expiration), 0, c.costOf(value), nil)
+}
+
+// SetSliding  data by key with sliding expiration，it will overwrite the data if the key exists
+// The data expires when it is not read for the idle timeout, every read extends the expiration time
+func (c *mapCache[K, E]) SetSliding(key K, value E, idle time.Duration) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	c.judgeAndInitItem()
+
+	c.set(key, value, c.generateExpirationForItem(idle), idle.Microseconds(), c.costOf(value), nil)
+}
+
+// SetWithCost  data by key with the cost of the data，it will overwrite the data if the key exists
+// The cost is not persisted, the data loaded from the persistence file is costed by the cost function
+func (c *mapCache[K, E]) SetWithCost(key K, value E, cost int64) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
 	c.judgeAndInitItem()
 
-	c.set(key, value, c.generateExpirationForItem(expiration))
+	c.set(key, value, c.generateExpiration(), c.generateIdle(), cost, nil)
 }
 
 // Add data，Cannot add existing data
 // To override the addition, use the set method
-func (c *mapCache[E]) Add(key string, value E) error {
+func (c *mapCache[K, E]) Add(key K, value E) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	if c.closed {
+		return ErrClosed
+	}
 	c.judgeAndInitItem()
 	if _, ok := c.items[key]; ok {
-		return fmt.Errorf("data %s already exists", key)
+		return fmt.Errorf("data %v already exists", key)
 	}
 
-	c.set(key, value, c.generateExpiration())
+	c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), nil)
 	return nil
 }
 
 // Get  data
 // When the data does not exist or expires, it will return nonexistence（false）
-func (c *mapCache[E]) Get(key string) (E, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	value, ok := c.items[key]
-	if !ok || value.expired() {
+func (c *mapCache[K, E]) Get(key K) (E, bool) {
+	unlock, exclusive := c.readLock()
+	defer unlock()
+	value, ok := c.lookup(key)
+	c.stats.read(ok)
+	if !ok {
 		var zero E
 		return zero, false
 	}
+	if exclusive {
+		c.touch(key, value)
+	}
+	c.access(key)
+	c.refresh(key, value, c.loader)
 	return value.Object, true
 }
 
 // GetAndDelete get data and delete by key
-func (c *mapCache[E]) GetAndDelete(key string) (E, bool) {
+func (c *mapCache[K, E]) GetAndDelete(key K) (E, bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 	value, ok := c.items[key]
 	if !ok || value.expired() {
+		c.stats.read(false)
 		var zero E
 		return zero, false
 	}
-	// delete
-	c.del(key)
-	return value.Object, true
+	object, ok := c.object(key, value)
+	c.stats.read(ok)
+	// delete, the data that cannot be read back is dropped too
+	c.del(key, ReasonDeleted)
+	return object, ok
 }
 
 // GetAndExpired  get data and expire by key
 // It will be deleted at the next clearing. If the clearing capability is not enabled, it will never be deleted
-func (c *mapCache[E]) GetAndExpired(key string) (E, bool) {
+func (c *mapCache[K, E]) GetAndExpired(key K) (E, bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	value, ok := c.items[key]
-	if !ok || value.expired() {
+	defer c.unlock()
+	value, ok := c.get(key)
+	if !ok {
+		c.stats.read(false)
 		var zero E
 		return zero, false
 	}
-	// SetDefault now as expiration time
-	c.set(key, value.Object, time.Now().UnixNano()/1e3)
+	c.stats.read(true)
+	// SetDefault now as expiration time, it is reported as expired when it is deleted
+	c.store(key, value.Object, time.Now().UnixNano()/1e3, 0, value.cost, value.tags)
 	return value.Object, true
 }
 
-func (c *mapCache[E]) GetWithExpiration(key string) (E, time.Time, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	value, ok := c.items[key]
-	if !ok || value.expired() {
+// GetWithExpiration get data and its expiration time
+// With sliding expiration, the expiration time is extended by reading, and the extended one is returned
+func (c *mapCache[K, E]) GetWithExpiration(key K) (E, time.Time, bool) {
+	unlock, exclusive := c.readLock()
+	defer unlock()
+	value, ok := c.lookup(key)
+	c.stats.read(ok)
+	if !ok {
 		var zero E
 		return zero, time.Time{}, false
 	}
+	if exclusive {
+		c.touch(key, value)
+	}
+	c.access(key)
+	c.refresh(key, value, c.loader)
 	return value.Object, time.UnixMicro(value.Expiration), true
 }
 
 // Clear remove all data
-func (c *mapCache[E]) Clear() {
-	c.items = make(map[string]*Item[E])
+func (c *mapCache[K, E]) Clear() {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	var zero E
+	for k, v := range c.items {
+		c.notify(k, v, ReasonCleared)
+		c.emit(EventClear, k, v, zero)
+	}
+	if c.disk != nil {
+		err := c.disk.clear()
+		if err != nil {
+			log.Printf("cache: clear the overflow store %s failed: %v", c.overflowDir(), err)
+		}
+	}
+	c.stats.remove(ReasonCleared, int64(len(c.items)))
+	c.items = make(map[K]*Item[E])
+	c.expiry.reset()
+	c.totalCost = 0
+	c.resident = 0
+	c.negative = nil
+	for key := range c.loads {
+		c.forget(key)
+	}
+	c.tags = nil
+	if c.evictor != nil {
+		c.evictor.reset()
+	}
+	c.dirty++
+	c.appendAof(&aofRecord[K, E]{Op: aofClear})
+}
+
+// Keys get all keys, the expired data is skipped
+func (c *mapCache[K, E]) Keys() []K {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res := make([]K, 0, len(c.items))
+	for k, v := range c.items {
+		if !v.expired() {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// Len get the number of data, the expired data is not counted
+func (c *mapCache[K, E]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res := 0
+	for _, v := range c.items {
+		if !v.expired() {
+			res++
+		}
+	}
+	return res
 }
 
-// Keys get all keys
-func (c *mapCache[E]) Keys() []string {
-	res := make([]string, 0)
-	for k := range c.items {
-		res = append(res, k)
+// Items get a copy of all data with the expiration time, the expired data is skipped
+func (c *mapCache[K, E]) Items() map[K]Item[E] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	res := make(map[K]Item[E], len(c.items))
+	for k, v := range c.items {
+		if v.expired() {
+			continue
+		}
+		// the data that cannot be read back is skipped, it is deleted by the next read of the key
+		if object, ok := c.object(k, v); ok {
+			res[k] = Item[E]{Object: object, Expiration: v.Expiration}
+		}
 	}
 	return res
 }
+
+// Range call fn for all data until it returns false, the expired data is skipped
+// fn is called on a copy of the data outside the lock, so it can use the cache
+func (c *mapCache[K, E]) Range(fn func(key K, value E) bool) {
+	for k, v := range c.Items() {
+		if !fn(k, v.Object) {
+			return
+		}
+	}
+}
+
+// Cost get the total cost of all data in memory, the data spilled to the overflow store costs nothing
+func (c *mapCache[K, E]) Cost() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalCost
+}
@@ -0,0 +1,518 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ShardedMapCache[E any] struct {
+	*shardedMapCache[E]
+}
+
+// shardedMapCache the keys are hashed across shards, every shard is a mapCache with its own lock
+// Operations on a single key only lock its shard, operations on all data visit the shards one by one,
+// so they are not atomic across shards
+type shardedMapCache[E any] struct {
+	shards    []*mapCache[string, E]
+	publisher *publisher[string, E] // shared by the shards, so the subscribers get the changes of all shards
+
+	mu     sync.Mutex // protects gc
+	stopGc chan bool
+	isGc   bool
+	options
+}
+
+// NewShardedMapCache create a cache with shards of mapCache
+// The maximum number and cost of data items are divided among the shards. With persistence every shard has
+// its own files named with the shard index, data is moved to its shard when the number of shards changes.
+// The files of removed shards and of an unsharded cache with the same name are loaded into the shards and removed
+func NewShardedMapCache[E any](opts ...CreateOptionFunc) (MapInterface[E], error) {
+	exp := newOption()
+	for _, opt := range opts {
+		opt(&exp)
+	}
+	if exp.shards <= 0 {
+		return nil, errors.New("the number of shards must be positive")
+	}
+	res := &shardedMapCache[E]{
+		shards:    make([]*mapCache[string, E], exp.shards),
+		publisher: newPublisher[string, E](exp.eventBuffer, exp.eventOverflow),
+		options:   exp,
+	}
+	shardExp := exp
+	shardExp.maxEntries = (exp.maxEntries + exp.shards - 1) / exp.shards
+	shardExp.maxCost = (exp.maxCost + int64(exp.shards) - 1) / int64(exp.shards)
+	for i := range res.shards {
+		shardExp.persistenceName = fmt.Sprintf("%s_%d", exp.persistenceName, i)
+		shard, err := newMapCache[string, E](shardExp)
+		if err != nil {
+			for _, s := range res.shards[:i] {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		shard.publisher = res.publisher
+		res.shards[i] = shard
+	}
+	res.rebalance()
+	err := res.adopt(shardExp)
+	if err != nil {
+		_ = res.each((*mapCache[string, E]).Close)
+		return nil, err
+	}
+	if exp.expiration != DefaultExpiration || exp.slidingExpiration > 0 {
+		// start gc
+		_ = res.StartGc()
+	}
+	c := &ShardedMapCache[E]{
+		res,
+	}
+	runtime.SetFinalizer(c, func(m *ShardedMapCache[E]) {
+		_ = m.Close()
+	})
+	return c, nil
+}
+
+// move the data loaded into a wrong shard, after the number of shards has changed
+func (c *shardedMapCache[E]) rebalance() {
+	for _, shard := range c.shards {
+		// the backup goroutine of the shard is already running
+		shard.mu.Lock()
+		for k, v := range shard.items {
+			to := c.shard(k)
+			if to == shard {
+				continue
+			}
+			value, ok := shard.object(k, v)
+			// the data is moved, it is neither written nor deleted in the statistics
+			shard.remove(k, ReasonDeleted)
+			if !ok {
+				// it cannot be read back, so it is dropped
+				continue
+			}
+			cost := v.cost
+			if v.cold {
+				cost = to.costOf(value)
+			}
+			to.mu.Lock()
+			to.judgeAndInitItem()
+			to.put(k, value, v.Expiration, v.idle, cost, v.tags)
+			to.unlock()
+		}
+		// the data is moved, not deleted
+		shard.evicted = nil
+		shard.unlock()
+	}
+}
+
+// load the files of the removed shards and of the unsharded cache into the shards, and remove them
+// The shards are flushed first, so the data is never only in the files removed
+func (c *shardedMapCache[E]) adopt(shardExp options) error {
+	if !c.enablePersistence {
+		return nil
+	}
+	var names []string
+	for i := len(c.shards); ; i++ {
+		shardExp.persistenceName = fmt.Sprintf("%s_%d", c.persistenceName, i)
+		if !shardExp.persisted() {
+			break
+		}
+		names = append(names, shardExp.persistenceName)
+	}
+	shardExp.persistenceName = c.persistenceName
+	if shardExp.persisted() {
+		names = append(names, c.persistenceName)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	// only the data is needed, the spilled data stays on disk while it is read
+	shardExp.onEvicted, shardExp.loader, shardExp.enableStats = nil, nil, false
+	if shardExp.persistencePolicy != Overflow {
+		shardExp.maxEntries, shardExp.maxCost = 0, 0
+	}
+	for _, name := range names {
+		shardExp.persistenceName = name
+		from, err := newMapCache[string, E](shardExp)
+		if err != nil {
+			return fmt.Errorf("load %s failed: %w", name, err)
+		}
+		from.mu.Lock()
+		for k, v := range from.items {
+			if v.expired() {
+				continue
+			}
+			value, ok := from.object(k, v)
+			if !ok {
+				continue
+			}
+			to := c.shard(k)
+			to.mu.Lock()
+			// the data in the shards is newer
+			if !to.contains(k) {
+				to.judgeAndInitItem()
+				to.put(k, value, v.Expiration, v.idle, to.costOf(value), v.tags)
+			}
+			to.unlock()
+		}
+		from.mu.Unlock()
+		_ = from.Close()
+	}
+	err := c.Flush()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		shardExp.persistenceName = name
+		err = shardExp.removePersistence()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shard of the key, FNV-1a hash
+func (c *shardedMapCache[E]) shard(key string) *mapCache[string, E] {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return c.shards[hash%uint32(len(c.shards))]
+}
+
+// Expired cache data Item cleanup
+func (c *shardedMapCache[E]) gcLoop(stop chan bool) {
+	ticker := time.NewTicker(c.gcInterval)
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// StartGc start gc
+// After the expiration time is set, GC will be started automatically without manual GC
+func (c *shardedMapCache[E]) StartGc() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if c.isGc {
+		return errors.New("GC has been started")
+	}
+	c.isGc = true
+	c.stopGc = make(chan bool)
+	go c.gcLoop(c.stopGc)
+	return nil
+}
+
+// StopGc stop gc
+func (c *shardedMapCache[E]) StopGc() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if !c.isGc {
+		return errors.New("GC is closed")
+	}
+	c.isGc = false
+	close(c.stopGc)
+	return nil
+}
+
+// judge whether the cache is closed, the shards are closed together
+func (c *shardedMapCache[E]) isClosed() bool {
+	shard := c.shards[0]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.closed
+}
+
+// IsExpired judge whether the data is expired
+func (c *shardedMapCache[E]) IsExpired(key string) (bool, error) {
+	return c.shard(key).IsExpired(key)
+}
+
+// DeleteExpired delete all expired data
+func (c *shardedMapCache[E]) DeleteExpired() {
+	for _, shard := range c.shards {
+		shard.DeleteExpired()
+	}
+}
+
+// Get  data
+// When the data does not exist or expires, it will return nonexistence（false）
+func (c *shardedMapCache[E]) Get(key string) (E, bool) {
+	return c.shard(key).Get(key)
+}
+
+// GetAndDelete get data and delete by key
+func (c *shardedMapCache[E]) GetAndDelete(key string) (E, bool) {
+	return c.shard(key).GetAndDelete(key)
+}
+
+// GetAndExpired  get data and expire by key
+// It will be deleted at the next clearing. If the clearing capability is not enabled, it will never be deleted
+func (c *shardedMapCache[E]) GetAndExpired(key string) (E, bool) {
+	return c.shard(key).GetAndExpired(key)
+}
+
+// GetWithExpiration get data and its expiration time from its shard
+func (c *shardedMapCache[E]) GetWithExpiration(key string) (E, time.Time, bool) {
+	return c.shard(key).GetWithExpiration(key)
+}
+
+// Delete delete data by key
+func (c *shardedMapCache[E]) Delete(key string) (E, bool) {
+	return c.shard(key).Delete(key)
+}
+
+// Set  data by key，it will overwrite the data if the key exists
+func (c *shardedMapCache[E]) Set(key string, value E) {
+	c.shard(key).Set(key, value)
+}
+
+// SetDefault  data by key，it will overwrite the data if the key exists
+func (c *shardedMapCache[E]) SetDefault(key string, value E, expiration time.Duration) {
+	c.shard(key).SetDefault(key, value, expiration)
+}
+
+// SetSliding  data by key with sliding expiration，it will overwrite the data if the key exists
+func (c *shardedMapCache[E]) SetSliding(key string, value E, idle time.Duration) {
+	c.shard(key).SetSliding(key, value, idle)
+}
+
+// SetWithCost  data by key with the cost of the data，it will overwrite the data if the key exists
+func (c *shardedMapCache[E]) SetWithCost(key string, value E, cost int64) {
+	c.shard(key).SetWithCost(key, value, cost)
+}
+
+// Add data，Cannot add existing data
+// To override the addition, use the set method
+func (c *shardedMapCache[E]) Add(key string, value E) error {
+	return c.shard(key).Add(key, value)
+}
+
+// GetMany  get data by keys, the data of a shard is got under one lock
+func (c *shardedMapCache[E]) GetMany(keys []string) map[string]E {
+	res := make(map[string]E, len(keys))
+	for shard, keys := range c.groupKeys(keys) {
+		for k, v := range shard.GetMany(keys) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// SetMany  set data, the data of a shard is set under one lock
+func (c *shardedMapCache[E]) SetMany(items map[string]E, ttl time.Duration) {
+	groups := make(map[*mapCache[string, E]]map[string]E)
+	for k, v := range items {
+		shard := c.shard(k)
+		if groups[shard] == nil {
+			groups[shard] = make(map[string]E)
+		}
+		groups[shard][k] = v
+	}
+	for shard, items := range groups {
+		shard.SetMany(items, ttl)
+	}
+}
+
+// DeleteMany  delete data by keys, the data of a shard is deleted under one lock
+func (c *shardedMapCache[E]) DeleteMany(keys []string) int {
+	deleted := 0
+	for shard, keys := range c.groupKeys(keys) {
+		deleted += shard.DeleteMany(keys)
+	}
+	return deleted
+}
+
+// SetWithTags  data by key with tags，it will overwrite the data if the key exists
+func (c *shardedMapCache[E]) SetWithTags(key string, value E, ttl time.Duration, tags ...string) {
+	c.shard(key).SetWithTags(key, value, ttl, tags...)
+}
+
+// InvalidateTag  delete all data with the tag, and return the number of data deleted
+func (c *shardedMapCache[E]) InvalidateTag(tag string) int {
+	deleted := 0
+	for _, shard := range c.shards {
+		deleted += shard.InvalidateTag(tag)
+	}
+	return deleted
+}
+
+// DeletePrefix  delete all data whose key starts with the prefix, and return the number of data deleted
+func (c *shardedMapCache[E]) DeletePrefix(prefix string) int {
+	deleted := 0
+	for _, shard := range c.shards {
+		deleted += shard.deleteMatching(func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		})
+	}
+	return deleted
+}
+
+// Subscribe  subscribe the changes of the data of all shards
+// The events of a shard are received in the order of its changes
+func (c *shardedMapCache[E]) Subscribe(filter func(e Event[string, E]) bool) (<-chan Event[string, E], func()) {
+	return c.publisher.subscribe(filter)
+}
+
+// group the keys by shard
+func (c *shardedMapCache[E]) groupKeys(keys []string) map[*mapCache[string, E]][]string {
+	groups := make(map[*mapCache[string, E]][]string)
+	for _, k := range keys {
+		shard := c.shard(k)
+		groups[shard] = append(groups[shard], k)
+	}
+	return groups
+}
+
+// Update  update data by key atomically
+func (c *shardedMapCache[E]) Update(key string, fn func(old E, exists bool) (E, bool)) (E, bool) {
+	return c.shard(key).Update(key, fn)
+}
+
+// CompareAndSwap  set data by key to new if it is old atomically, and return whether it is swapped
+func (c *shardedMapCache[E]) CompareAndSwap(key string, old, new E) bool {
+	return c.shard(key).CompareAndSwap(key, old, new)
+}
+
+// CompareAndDelete  delete data by key if it is old atomically, and return whether it is deleted
+func (c *shardedMapCache[E]) CompareAndDelete(key string, old E) bool {
+	return c.shard(key).CompareAndDelete(key, old)
+}
+
+// GetOrSet  get data by key, and set it if it does not exist or expires
+func (c *shardedMapCache[E]) GetOrSet(key string, value E) (E, bool) {
+	return c.shard(key).GetOrSet(key, value)
+}
+
+// Replace  set data by key only if it exists, and return whether it is set
+func (c *shardedMapCache[E]) Replace(key string, value E) bool {
+	return c.shard(key).Replace(key, value)
+}
+
+// GetOrLoad get data, and load it by the loader when it does not exist or expires
+func (c *shardedMapCache[E]) GetOrLoad(key string, loader func(key string) (E, error)) (E, error) {
+	return c.shard(key).GetOrLoad(key, loader)
+}
+
+// Clear remove all data
+func (c *shardedMapCache[E]) Clear() {
+	for _, shard := range c.shards {
+		shard.Clear()
+	}
+}
+
+// Keys get all keys, the expired data is skipped
+func (c *shardedMapCache[E]) Keys() []string {
+	res := make([]string, 0)
+	for _, shard := range c.shards {
+		res = append(res, shard.Keys()...)
+	}
+	return res
+}
+
+// Len get the number of data, the expired data is not counted
+func (c *shardedMapCache[E]) Len() int {
+	res := 0
+	for _, shard := range c.shards {
+		res += shard.Len()
+	}
+	return res
+}
+
+// Items get a copy of all data with the expiration time, the data of a shard is copied under one lock
+func (c *shardedMapCache[E]) Items() map[string]Item[E] {
+	res := make(map[string]Item[E])
+	for _, shard := range c.shards {
+		for k, v := range shard.Items() {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// Range call fn for all data until it returns false, the expired data is skipped
+func (c *shardedMapCache[E]) Range(fn func(key string, value E) bool) {
+	for _, shard := range c.shards {
+		for k, v := range shard.Items() {
+			if !fn(k, v.Object) {
+				return
+			}
+		}
+	}
+}
+
+// Stats get the statistics of all shards, all zero when they are not enabled
+func (c *shardedMapCache[E]) Stats() Stats {
+	var res Stats
+	for _, shard := range c.shards {
+		res = res.add(shard.Stats())
+	}
+	return res
+}
+
+// Cost get the total cost of all data
+func (c *shardedMapCache[E]) Cost() int64 {
+	var res int64
+	for _, shard := range c.shards {
+		res += shard.Cost()
+	}
+	return res
+}
+
+// RewriteAof rewrite the append only files of all shards one by one
+func (c *shardedMapCache[E]) RewriteAof() error {
+	return c.each((*mapCache[string, E]).RewriteAof)
+}
+
+// Flush write the data of all shards to the persistence files now
+func (c *shardedMapCache[E]) Flush() error {
+	return c.each((*mapCache[string, E]).Flush)
+}
+
+// Close close the shards
+// The wrapper is kept alive until they are closed, otherwise its finalizer could close them at the same time
+func (c *ShardedMapCache[E]) Close() error {
+	defer runtime.KeepAlive(c)
+	return c.shardedMapCache.Close()
+}
+
+// Close stop gc and persistence, and write the data to the persistence files for the last time
+func (c *shardedMapCache[E]) Close() error {
+	c.mu.Lock()
+	if c.isGc {
+		c.isGc = false
+		close(c.stopGc)
+	}
+	c.mu.Unlock()
+	return c.each((*mapCache[string, E]).Close)
+}
+
+// call fn on all shards, and return the first error
+func (c *shardedMapCache[E]) each(fn func(*mapCache[string, E]) error) error {
+	var res error
+	for _, shard := range c.shards {
+		err := fn(shard)
+		if err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// SetWithTags  data by key with tags，it will overwrite the data if the key exists
+// The data expires after ttl. When ttl is 0 the default expiration time is used, when it is DefaultExpiration
+// the data never expires. The data can be deleted by any of its tags with InvalidateTag
+func (c *mapCache[K, E]) SetWithTags(key K, value E, ttl time.Duration, tags ...string) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.closed {
+		return
+	}
+	c.judgeAndInitItem()
+
+	tags = uniqueTags(tags)
+	switch ttl {
+	case 0:
+		c.set(key, value, c.generateExpiration(), c.generateIdle(), c.costOf(value), tags)
+	case DefaultExpiration:
+		c.set(key, value, 0, 0, c.costOf(value), tags)
+	default:
+		c.set(key, value, c.generateExpirationForItem(ttl), 0, c.costOf(value), tags)
+	}
+}
+
+// InvalidateTag  delete all data with the tag, and return the number of data deleted
+// The expired data with the tag is deleted as well, but it is not counted
+func (c *mapCache[K, E]) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	defer c.unlock()
+	deleted := 0
+	for key := range c.tags[tag] {
+		if c.contains(key) {
+			deleted++
+		}
+		c.del(key, ReasonDeleted)
+	}
+	return deleted
+}
+
+// delete all data whose key matches, and return the number of data deleted
+// The expired data matching is deleted as well, but it is not counted
+func (c *mapCache[K, E]) deleteMatching(match func(key K) bool) int {
+	c.mu.Lock()
+	defer c.unlock()
+	deleted := 0
+	for key := range c.items {
+		if !match(key) {
+			continue
+		}
+		if c.contains(key) {
+			deleted++
+		}
+		c.del(key, ReasonDeleted)
+	}
+	for key := range c.negative {
+		if match(key) {
+			delete(c.negative, key)
+		}
+	}
+	for key := range c.loads {
+		if match(key) {
+			c.forget(key)
+		}
+	}
+	return deleted
+}
+
+// DeletePrefix  delete all data whose key starts with the prefix, and return the number of data deleted
+func (c *MapCache[E]) DeletePrefix(prefix string) int {
+	return c.deleteMatching(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// index the key by its tags, c.mu must be held
+func (c *mapCache[K, E]) tag(key K, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[K]struct{})
+	}
+	for _, t := range tags {
+		keys, ok := c.tags[t]
+		if !ok {
+			keys = make(map[K]struct{})
+			c.tags[t] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// remove the key from the index of its tags, c.mu must be held
+func (c *mapCache[K, E]) untag(key K, tags []string) {
+	for _, t := range tags {
+		keys := c.tags[t]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tags, t)
+		}
+	}
+}
+
+// copy the tags without duplicates, nil when there are no tags
+func uniqueTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		res = append(res, t)
+	}
+	return res
+}
@@ -0,0 +1,421 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// WriteMode  how the tiered cache writes L2
+type WriteMode int
+
+const (
+	// WriteThrough L2 is written before L1, the write fails if L2 fails
+	WriteThrough WriteMode = iota
+	// WriteBehind L2 is written in the background in the order of the writes, the errors are logged
+	WriteBehind
+)
+
+// the data is missing from L2, it is returned by the loader of L1
+var errL2Miss = errors.New("missing from L2")
+
+// the read of L2 shared by the concurrent misses is stopped by the context of the caller running it
+type canceledRead struct {
+	err error
+}
+
+func (e *canceledRead) Error() string {
+	return e.err.Error()
+}
+
+func (e *canceledRead) Unwrap() error {
+	return e.err
+}
+
+// TieredCache a small and fast MapCache (L1) in front of a larger and slower Backend (L2)
+// Reading misses of L1 are read through from L2, concurrent misses on a key are coalesced into a single read,
+// and the data found in L2 is promoted into L1 with the expiration time of L1
+type TieredCache[E any] struct {
+	l1 *mapCache[string, E]
+	l2 Backend[E]
+
+	mu         sync.RWMutex // protects closed and sending to queue
+	closed     bool
+	queue      chan tieredWrite[E] // writes of L2 with WriteBehind
+	writerDone chan struct{}       // closed when the queue is drained
+
+	pendingMu sync.Mutex
+	pending   map[string]*pendingWrite[E] // the keys with writes in the queue, read through instead of L2
+	seq       uint64
+	options
+}
+
+// the writes of a key in the queue with WriteBehind
+type pendingWrite[E any] struct {
+	last   tieredWrite[E] // the latest one, what L2 has when the queue is drained
+	queued int
+	seq    uint64 // seq of the latest one
+}
+
+// a write of L2 queued with WriteBehind
+type tieredWrite[E any] struct {
+	key    string
+	value  E
+	delete bool
+	done   chan struct{} // not nil for Flush, it is closed when the writes before it are done
+}
+
+// NewTieredCache create a tiered cache, L1 is created with the options
+// The expiration time of L1 must be shorter than L2, so L1 never serves data L2 has dropped.
+// The cache must be closed to stop writing behind
+func NewTieredCache[E any](l2 Backend[E], opts ...CreateOptionFunc) (*TieredCache[E], error) {
+	if l2 == nil {
+		return nil, errors.New("the L2 backend is nil")
+	}
+	l1, err := startMapCache[string, E](opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &TieredCache[E]{
+		l1:      l1,
+		l2:      l2,
+		options: l1.options,
+	}
+	if c.l2Expiration > 0 && (c.expiration <= 0 || c.expiration >= c.l2Expiration) {
+		_ = l1.Close()
+		return nil, errors.New("the expiration time of L1 must be shorter than L2")
+	}
+	if c.writeMode == WriteBehind {
+		if c.writeQueue < 0 {
+			c.writeQueue = 0
+		}
+		c.queue = make(chan tieredWrite[E], c.writeQueue)
+		c.pending = make(map[string]*pendingWrite[E])
+		c.writerDone = make(chan struct{})
+		go c.writeBehind()
+	}
+	return c, nil
+}
+
+// L1 the cache in front of L2, the changes made through it do not reach L2
+func (c *TieredCache[E]) L1() MapInterface[E] {
+	return &MapCache[E]{c.l1}
+}
+
+// Map  the cache as a MapInterface, such as for Broadcaster
+// Get and GetMany read through from L2, Set, SetMany, Delete and DeleteMany write L2 like the methods of TieredCache
+// with context.Background(), the errors are logged and L1 is not changed then. Close closes the TieredCache, the other
+// methods use L1 only
+func (c *TieredCache[E]) Map() MapInterface[E] {
+	return &tieredMap[E]{MapCache: &MapCache[E]{c.l1}, c: c}
+}
+
+// Get  get data from L1, or read it from L2 and promote it into L1
+// The read of L2 shared by the concurrent misses runs with the context of one of them, when it is canceled the
+// others read again with their own
+func (c *TieredCache[E]) Get(ctx context.Context, key string) (E, bool, error) {
+	var zero E
+	value, err := c.l1.GetOrLoad(key, func(key string) (E, error) {
+		// L2 is behind the writes still in the queue
+		if w, ok := c.pendingWrite(key); ok {
+			if w.delete {
+				return zero, errL2Miss
+			}
+			return w.value, nil
+		}
+		value, ok, err := c.l2.Get(ctx, key)
+		if err != nil && ctx.Err() != nil {
+			err = &canceledRead{err}
+		}
+		if err == nil && !ok {
+			err = errL2Miss
+		}
+		return value, err
+	})
+	var canceled *canceledRead
+	if errors.As(err, &canceled) {
+		if ctx.Err() != nil {
+			return zero, false, ctx.Err()
+		}
+		return c.Get(ctx, key)
+	}
+	if err == errL2Miss {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// Set  set data in both levels
+// With WriteThrough L1 is not changed if writing L2 fails. With WriteBehind the write of L2 is queued
+func (c *TieredCache[E]) Set(ctx context.Context, key string, value E) error {
+	if err := c.setL2(ctx, key, value); err != nil {
+		return err
+	}
+	c.l1.Set(key, value)
+	return nil
+}
+
+// Delete  delete data from both levels
+// Like Set, L2 is deleted (or the delete is queued) before L1, so reading in between does not bring the data back
+func (c *TieredCache[E]) Delete(ctx context.Context, key string) error {
+	if err := c.deleteL2(ctx, key); err != nil {
+		return err
+	}
+	c.l1.Delete(key)
+	return nil
+}
+
+// Flush  wait until the writes of L2 queued before are done, it returns at once with WriteThrough
+func (c *TieredCache[E]) Flush(ctx context.Context) error {
+	if c.writeMode != WriteBehind {
+		return c.checkClosed()
+	}
+	done := make(chan struct{})
+	err := c.enqueue(ctx, tieredWrite[E]{done: done})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close  write the queued writes to L2, and close L1
+func (c *TieredCache[E]) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	if c.queue != nil {
+		close(c.queue)
+	}
+	c.mu.Unlock()
+	if c.writerDone != nil {
+		<-c.writerDone
+	}
+	return c.l1.Close()
+}
+
+func (c *TieredCache[E]) checkClosed() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// write the data to L2 through, or queue the write with WriteBehind
+func (c *TieredCache[E]) setL2(ctx context.Context, key string, value E) error {
+	if c.writeMode == WriteBehind {
+		return c.enqueue(ctx, tieredWrite[E]{key: key, value: value})
+	}
+	return c.write(ctx, key, value)
+}
+
+// delete the data from L2, or queue the delete with WriteBehind
+func (c *TieredCache[E]) deleteL2(ctx context.Context, key string) error {
+	if err := c.checkClosed(); err != nil {
+		return err
+	}
+	if c.writeMode == WriteBehind {
+		return c.enqueue(ctx, tieredWrite[E]{key: key, delete: true})
+	}
+	return c.l2.Delete(ctx, key)
+}
+
+// write the data to L2 through
+func (c *TieredCache[E]) write(ctx context.Context, key string, value E) error {
+	if err := c.checkClosed(); err != nil {
+		return err
+	}
+	return c.l2.Set(ctx, key, value, c.l2Expiration)
+}
+
+// queue a write of L2, it waits when the queue is full
+func (c *TieredCache[E]) enqueue(ctx context.Context, w tieredWrite[E]) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	var seq uint64
+	var prev tieredWrite[E]
+	if w.done == nil {
+		seq, prev = c.addPending(w)
+	}
+	select {
+	case c.queue <- w:
+		return nil
+	case <-ctx.Done():
+		if w.done == nil {
+			c.unqueuePending(w.key, seq, prev)
+		}
+		return ctx.Err()
+	}
+}
+
+// the latest queued write of the key
+func (c *TieredCache[E]) pendingWrite(key string) (tieredWrite[E], bool) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	p, ok := c.pending[key]
+	if !ok {
+		return tieredWrite[E]{}, false
+	}
+	return p.last, true
+}
+
+// record the write before queueing it, and return its seq and the latest write of the key before it
+func (c *TieredCache[E]) addPending(w tieredWrite[E]) (uint64, tieredWrite[E]) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	p, ok := c.pending[w.key]
+	if !ok {
+		p = &pendingWrite[E]{}
+		c.pending[w.key] = p
+	}
+	c.seq++
+	prev := p.last
+	p.last, p.seq = w, c.seq
+	p.queued++
+	return c.seq, prev
+}
+
+// a write of the key is done
+func (c *TieredCache[E]) removePending(key string) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.dropPending(key)
+}
+
+// a write of the key failed to be queued, the write before it is the latest again if no write followed it
+func (c *TieredCache[E]) unqueuePending(key string, seq uint64, prev tieredWrite[E]) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if p, ok := c.dropPending(key); ok && p.seq == seq {
+		p.last = prev
+	}
+}
+
+// count a write of the key out of the queue, and return what is left
+func (c *TieredCache[E]) dropPending(key string) (*pendingWrite[E], bool) {
+	p, ok := c.pending[key]
+	if !ok {
+		return nil, false
+	}
+	p.queued--
+	if p.queued == 0 {
+		delete(c.pending, key)
+		return nil, false
+	}
+	return p, true
+}
+
+// write the queued writes to L2 in order until the queue is closed
+func (c *TieredCache[E]) writeBehind() {
+	defer close(c.writerDone)
+	ctx := context.Background()
+	for w := range c.queue {
+		var err error
+		switch {
+		case w.done != nil:
+			close(w.done)
+		case w.delete:
+			err = c.l2.Delete(ctx, w.key)
+		default:
+			err = c.l2.Set(ctx, w.key, w.value, c.l2Expiration)
+		}
+		if err != nil {
+			log.Printf("cache: write %s behind to L2 failed: %v", w.key, err)
+		}
+		if w.done == nil {
+			c.removePending(w.key)
+		}
+	}
+}
+
+// tieredMap TieredCache as a MapInterface, the methods not overridden use L1 only
+type tieredMap[E any] struct {
+	*MapCache[E]
+	c *TieredCache[E]
+}
+
+// Get  get data from L1, or read it from L2
+func (m *tieredMap[E]) Get(key string) (E, bool) {
+	value, ok, err := m.c.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("cache: read %s from L2 failed: %v", key, err)
+	}
+	return value, ok
+}
+
+// GetMany  get data by keys from L1, or read them from L2
+func (m *tieredMap[E]) GetMany(keys []string) map[string]E {
+	res := make(map[string]E, len(keys))
+	for _, key := range keys {
+		if value, ok := m.Get(key); ok {
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// Set  set data in both levels
+func (m *tieredMap[E]) Set(key string, value E) {
+	if err := m.c.Set(context.Background(), key, value); err != nil {
+		log.Printf("cache: write %s to L2 failed: %v", key, err)
+	}
+}
+
+// SetMany  set data in both levels, the data failed to be written to L2 is not set in L1
+func (m *tieredMap[E]) SetMany(items map[string]E, ttl time.Duration) {
+	written := make(map[string]E, len(items))
+	for key, value := range items {
+		if err := m.c.setL2(context.Background(), key, value); err != nil {
+			log.Printf("cache: write %s to L2 failed: %v", key, err)
+			continue
+		}
+		written[key] = value
+	}
+	m.MapCache.SetMany(written, ttl)
+}
+
+// Delete  delete data from both levels, and return the data deleted from L1
+func (m *tieredMap[E]) Delete(key string) (E, bool) {
+	if err := m.c.deleteL2(context.Background(), key); err != nil {
+		log.Printf("cache: delete %s from L2 failed: %v", key, err)
+		var zero E
+		return zero, false
+	}
+	return m.MapCache.Delete(key)
+}
+
+// DeleteMany  delete data from both levels, and return the number of data deleted from L1
+// The data failed to be deleted from L2 is kept in L1
+func (m *tieredMap[E]) DeleteMany(keys []string) int {
+	deleted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := m.c.deleteL2(context.Background(), key); err != nil {
+			log.Printf("cache: delete %s from L2 failed: %v", key, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	return m.MapCache.DeleteMany(deleted)
+}
+
+// Close  write the queued writes to L2, and close L1
+func (m *tieredMap[E]) Close() error {
+	return m.c.Close()
+}
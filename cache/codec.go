@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec serialize the data of persistence
+// The name of the codec is recorded in the persistence files, so it must be unique and stable
+type Codec interface {
+	// Name name of the codec
+	Name() string
+	// Marshal encode v
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decode data into v, v must be a pointer
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// GobCodec encoding/gob, it is the default codec
+	// Interface values must be registered with gob.Register
+	GobCodec Codec = gobCodec{}
+	// JSONCodec encoding/json, the files can be read from other languages
+	JSONCodec Codec = jsonCodec{}
+	// BinaryCodec compact binary length-prefixed format
+	// Types with unexported fields can implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// built-in codecs by name
+var codecs = map[string]Codec{
+	GobCodec.Name():    GobCodec,
+	JSONCodec.Name():   JSONCodec,
+	BinaryCodec.Name(): BinaryCodec,
+}
+
+// find the codec recorded in a persistence file
+// The configured codec is preferred, so a user codec can be found by its name
+func findCodec(name string, configured Codec) (Codec, error) {
+	if configured != nil && configured.Name() == name {
+		return configured, nil
+	}
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %s", name)
+	}
+	return codec, nil
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,398 @@
+package cache
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// binaryCodec a compact binary length-prefixed format
+//
+// Integers are varint encoded, floats are fixed size, strings, slices and maps are prefixed with
+// their length (slices and maps with length + 1, so that nil can be told apart) and pointers with
+// a nil flag. Only exported struct fields are encoded, in declaration order.
+// Types implementing encoding.BinaryMarshaler are encoded as a length-prefixed byte string.
+// Interfaces, channels and functions are not supported.
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) Marshal(v any) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	e := &binaryEncoder{}
+	err := e.encode(rv)
+	if err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (binaryCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("binary: unmarshal requires a non-nil pointer")
+	}
+	d := &binaryDecoder{data: data}
+	err := d.decode(rv.Elem())
+	if err != nil {
+		return err
+	}
+	if len(d.data) != 0 {
+		return errors.New("binary: trailing data")
+	}
+	return nil
+}
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	byteType              = reflect.TypeOf(byte(0))
+	errBinaryShort        = errors.New("binary: unexpected end of data")
+)
+
+type binaryEncoder struct {
+	buf []byte
+}
+
+func (e *binaryEncoder) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+func (e *binaryEncoder) varint(x int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+func (e *binaryEncoder) uint32(x uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *binaryEncoder) uint64(x uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *binaryEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *binaryEncoder) encode(v reflect.Value) error {
+	t := v.Type()
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(binaryMarshalerType) {
+		// copy the value, so that a pointer receiver can be called on unaddressable values
+		p := reflect.New(t)
+		p.Elem().Set(v)
+		data, err := p.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.bytes(data)
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uvarint(v.Uint())
+	case reflect.Float32:
+		e.uint32(math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.uint64(math.Float64bits(v.Float()))
+	case reflect.Complex64:
+		c := v.Complex()
+		e.uint32(math.Float32bits(float32(real(c))))
+		e.uint32(math.Float32bits(float32(imag(c))))
+	case reflect.Complex128:
+		c := v.Complex()
+		e.uint64(math.Float64bits(real(c)))
+		e.uint64(math.Float64bits(imag(c)))
+	case reflect.String:
+		e.uvarint(uint64(v.Len()))
+		e.buf = append(e.buf, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		e.uvarint(uint64(v.Len()) + 1)
+		if t.Elem() == byteType {
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		return e.elements(v)
+	case reflect.Array:
+		return e.elements(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.uvarint(0)
+			return nil
+		}
+		e.uvarint(uint64(v.Len()) + 1)
+		iter := v.MapRange()
+		for iter.Next() {
+			err := e.encode(iter.Key())
+			if err != nil {
+				return err
+			}
+			err = e.encode(iter.Value())
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			err := e.encode(v.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("binary: unsupported type %s", t)
+	}
+	return nil
+}
+
+func (e *binaryEncoder) elements(v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		err := e.encode(v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type binaryDecoder struct {
+	data []byte
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errBinaryShort
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) varint() (int64, error) {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		return 0, errBinaryShort
+	}
+	d.data = d.data[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.data)) < n {
+		return nil, errBinaryShort
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *binaryDecoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+// length of a slice or map, ok is false when it is nil
+func (d *binaryDecoder) length() (n int, ok bool, err error) {
+	x, err := d.uvarint()
+	if err != nil || x == 0 {
+		return 0, false, err
+	}
+	if x-1 > math.MaxInt32 {
+		return 0, false, errors.New("binary: invalid length")
+	}
+	return int(x - 1), true, nil
+}
+
+// capacity to preallocate, bounded by the remaining data
+func (d *binaryDecoder) capacity(n int) int {
+	if n > len(d.data) {
+		return len(d.data)
+	}
+	return n
+}
+
+func (d *binaryDecoder) decode(v reflect.Value) error {
+	t := v.Type()
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(binaryUnmarshalerType) {
+		data, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float32:
+		b, err := d.next(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.Complex64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		re := math.Float32frombits(binary.LittleEndian.Uint32(b[:4]))
+		im := math.Float32frombits(binary.LittleEndian.Uint32(b[4:]))
+		v.SetComplex(complex(float64(re), float64(im)))
+	case reflect.Complex128:
+		b, err := d.next(16)
+		if err != nil {
+			return err
+		}
+		re := math.Float64frombits(binary.LittleEndian.Uint64(b[:8]))
+		im := math.Float64frombits(binary.LittleEndian.Uint64(b[8:]))
+		v.SetComplex(complex(re, im))
+	case reflect.String:
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, ok, err := d.length()
+		if err != nil || !ok {
+			v.Set(reflect.Zero(t))
+			return err
+		}
+		if t.Elem() == byteType {
+			b, err := d.next(uint64(n))
+			if err != nil {
+				return err
+			}
+			s := reflect.MakeSlice(t, n, n)
+			reflect.Copy(s, reflect.ValueOf(b))
+			v.Set(s)
+			return nil
+		}
+		s := reflect.MakeSlice(t, 0, d.capacity(n))
+		for i := 0; i < n; i++ {
+			elem := reflect.New(t.Elem()).Elem()
+			err = d.decode(elem)
+			if err != nil {
+				return err
+			}
+			s = reflect.Append(s, elem)
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			err := d.decode(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, ok, err := d.length()
+		if err != nil || !ok {
+			v.Set(reflect.Zero(t))
+			return err
+		}
+		m := reflect.MakeMapWithSize(t, d.capacity(n))
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			err = d.decode(key)
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			err = d.decode(elem)
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			err := d.decode(v.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == 0 {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		err = d.decode(p.Elem())
+		if err != nil {
+			return err
+		}
+		v.Set(p)
+	default:
+		return fmt.Errorf("binary: unsupported type %s", t)
+	}
+	return nil
+}
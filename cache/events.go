@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventOp  what changed the data
+type EventOp int
+
+const (
+	// EventSet the data was set or overwritten
+	EventSet EventOp = iota
+	// EventDelete the data was deleted
+	EventDelete
+	// EventExpire the data expired and was deleted
+	EventExpire
+	// EventEvict the data was evicted because the cache is full
+	EventEvict
+	// EventClear the data was removed by Clear
+	EventClear
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventClear:
+		return "clear"
+	default:
+		return "unknown"
+	}
+}
+
+// OverflowPolicy  what happens when the buffer of a subscriber is full
+type OverflowPolicy int
+
+const (
+	// OverflowDrop the event is dropped for the subscriber
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock the change waits until the subscriber receives the event or is canceled. It waits after the lock
+	// of the cache is released, so the subscriber can read the cache, but it must not change it while it is behind,
+	// since the change would wait for the subscriber itself
+	OverflowBlock
+	// OverflowDisconnect the subscriber is canceled and its channel is closed
+	OverflowDisconnect
+)
+
+// Event  a change of the data
+type Event[K comparable, E any] struct {
+	Op   EventOp
+	Key  K
+	Old  E // data before the change, the zero value if it did not exist
+	New  E // data after the change, the zero value if it is removed
+	Time time.Time
+}
+
+// Subscribe  subscribe the changes of the data, and return the events and the function to cancel the subscription
+// filter chooses the events, nil means all events. It must not use the cache. The events are received in the order
+// of the changes, the channel is closed when the subscription is canceled or the cache is closed
+func (c *mapCache[K, E]) Subscribe(filter func(e Event[K, E]) bool) (<-chan Event[K, E], func()) {
+	return c.publisher.subscribe(filter)
+}
+
+// record a change for the subscribers, it is published after the lock is released, c.mu must be held
+// old is the item before the change, nil if it did not exist
+// The old data that cannot be read back is treated as nonexistent, so removing it is not published
+func (c *mapCache[K, E]) emit(op EventOp, key K, old *Item[E], new E) {
+	if !c.publisher.active() {
+		return
+	}
+	var oldValue E
+	if old != nil {
+		var ok bool
+		oldValue, ok = c.object(key, old)
+		if !ok && op != EventSet {
+			return
+		}
+	}
+	c.events = append(c.events, Event[K, E]{Op: op, Key: key, Old: oldValue, New: new, Time: time.Now()})
+}
+
+// the operation removing the data for the reason
+func removeOp[E any](item *Item[E], reason EvictReason) EventOp {
+	switch {
+	case reason == ReasonCleared:
+		return EventClear
+	case reason == ReasonExpired || item.expired():
+		return EventExpire
+	case reason == ReasonEvicted:
+		return EventEvict
+	default:
+		return EventDelete
+	}
+}
+
+// publisher deliver the events to the subscribers
+// The sharded cache shares one publisher among its shards
+type publisher[K comparable, E any] struct {
+	mu          sync.Mutex
+	subscribers []*subscriber[K, E]
+	count       int32 // number of subscribers, accessed atomically
+	closed      bool
+	buffer      int
+	overflow    OverflowPolicy
+
+	// the changes take tickets in order under the lock of the cache, and publish in the order of the tickets
+	// after releasing it, so a blocked subscriber never holds the lock of the cache
+	turnMu  sync.Mutex
+	turn    *sync.Cond
+	next    uint64
+	serving uint64
+}
+
+type subscriber[K comparable, E any] struct {
+	ch     chan Event[K, E]
+	filter func(e Event[K, E]) bool
+	done   chan struct{} // closed when the subscription is canceled
+	once   sync.Once
+}
+
+func newPublisher[K comparable, E any](buffer int, overflow OverflowPolicy) *publisher[K, E] {
+	if buffer < 0 {
+		buffer = 0
+	}
+	p := &publisher[K, E]{buffer: buffer, overflow: overflow}
+	p.turn = sync.NewCond(&p.turnMu)
+	return p
+}
+
+// judge whether there are subscribers
+func (p *publisher[K, E]) active() bool {
+	return atomic.LoadInt32(&p.count) > 0
+}
+
+func (p *publisher[K, E]) subscribe(filter func(e Event[K, E]) bool) (<-chan Event[K, E], func()) {
+	s := &subscriber[K, E]{
+		ch:     make(chan Event[K, E], p.buffer),
+		filter: filter,
+		done:   make(chan struct{}),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	p.subscribers = append(p.subscribers, s)
+	atomic.AddInt32(&p.count, 1)
+	return s.ch, func() {
+		// wake up the publisher blocked on the subscriber first
+		s.cancel()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.remove(s)
+	}
+}
+
+// publish the events, unlock releases the lock of the cache after a ticket is taken, so the events of the changes
+// made under the lock are published in order, without holding it while waiting for the subscribers
+func (p *publisher[K, E]) publish(events []Event[K, E], unlock func()) {
+	p.turnMu.Lock()
+	ticket := p.next
+	p.next++
+	p.turnMu.Unlock()
+	unlock()
+
+	p.turnMu.Lock()
+	for p.serving != ticket {
+		p.turn.Wait()
+	}
+	p.turnMu.Unlock()
+	defer func() {
+		p.turnMu.Lock()
+		p.serving++
+		p.turn.Broadcast()
+		p.turnMu.Unlock()
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.subscribers {
+		for _, e := range events {
+			if s.filter != nil && !s.filter(e) {
+				continue
+			}
+			if !p.send(s, e) {
+				break
+			}
+		}
+	}
+}
+
+// send an event to a subscriber, and return whether the subscriber is still subscribed, p.mu must be held
+func (p *publisher[K, E]) send(s *subscriber[K, E], e Event[K, E]) bool {
+	switch p.overflow {
+	case OverflowBlock:
+		select {
+		case s.ch <- e:
+			return true
+		case <-s.done:
+			return false
+		}
+	case OverflowDisconnect:
+		select {
+		case s.ch <- e:
+			return true
+		default:
+			s.cancel()
+			p.remove(s)
+			return false
+		}
+	default:
+		select {
+		case s.ch <- e:
+		default:
+		}
+		return true
+	}
+}
+
+// remove a subscriber and close its channel, p.mu must be held
+// The subscribers are copied, so that publish can go on with the old ones
+func (p *publisher[K, E]) remove(s *subscriber[K, E]) {
+	for i, x := range p.subscribers {
+		if x == s {
+			p.subscribers = append(p.subscribers[:i:i], p.subscribers[i+1:]...)
+			atomic.AddInt32(&p.count, -1)
+			close(s.ch)
+			return
+		}
+	}
+}
+
+// close all subscriptions, the later ones are closed at once
+func (p *publisher[K, E]) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, s := range p.subscribers {
+		s.cancel()
+		close(s.ch)
+	}
+	p.subscribers = nil
+	atomic.StoreInt32(&p.count, 0)
+}
+
+func (s *subscriber[K, E]) cancel() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
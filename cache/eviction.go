@@ -0,0 +1,127 @@
+package cache
+
+import "container/list"
+
+// EvictionPolicy  policy
+type EvictionPolicy int
+
+const (
+	// LRU Least Recently Used
+	LRU EvictionPolicy = iota
+	// LFU Least Frequently Used, with dynamic aging so that data used frequently long ago can be evicted
+	LFU
+	// ARC Adaptive Replacement Cache, balance between recency and frequency
+	ARC
+)
+
+// EvictReason  why the data left the cache
+type EvictReason int
+
+const (
+	// ReasonExpired the data expired and was deleted
+	ReasonExpired EvictReason = iota
+	// ReasonEvicted the data was evicted because the cache is full
+	ReasonEvicted
+	// ReasonDeleted the data was deleted
+	ReasonDeleted
+	// ReasonReplaced the data was overwritten
+	ReasonReplaced
+	// ReasonCleared the data was removed by Clear
+	ReasonCleared
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonReplaced:
+		return "replaced"
+	case ReasonCleared:
+		return "cleared"
+	default:
+		return "unknown"
+	}
+}
+
+// evictor track the usage of the data and decide which data is evicted when the cache is full
+type evictor[K comparable] interface {
+	// add a key
+	add(key K)
+	// access a key is read or overwritten
+	access(key K)
+	// remove a key
+	remove(key K)
+	// victim the key to be evicted, it will be removed afterwards
+	victim() (K, bool)
+	// reset remove all keys
+	reset()
+}
+
+func newEvictor[K comparable](policy EvictionPolicy, capacity int) evictor[K] {
+	switch policy {
+	case LFU:
+		return newLfu[K]()
+	case ARC:
+		return newArc[K](capacity)
+	default:
+		return newLru[K]()
+	}
+}
+
+// lru least recently used order of keys
+// The front of the list is the most recently used key
+type lru[K comparable] struct {
+	ll    *list.List
+	elems map[K]*list.Element
+}
+
+func newLru[K comparable]() *lru[K] {
+	return &lru[K]{
+		ll:    list.New(),
+		elems: make(map[K]*list.Element),
+	}
+}
+
+// add a key as the most recently used
+func (l *lru[K]) add(key K) {
+	if e, ok := l.elems[key]; ok {
+		l.ll.MoveToFront(e)
+		return
+	}
+	l.elems[key] = l.ll.PushFront(key)
+}
+
+// access mark the key as the most recently used
+func (l *lru[K]) access(key K) {
+	if e, ok := l.elems[key]; ok {
+		l.ll.MoveToFront(e)
+	}
+}
+
+// remove a key
+func (l *lru[K]) remove(key K) {
+	if e, ok := l.elems[key]; ok {
+		l.ll.Remove(e)
+		delete(l.elems, key)
+	}
+}
+
+// victim the least recently used key
+func (l *lru[K]) victim() (K, bool) {
+	e := l.ll.Back()
+	if e == nil {
+		var zero K
+		return zero, false
+	}
+	return e.Value.(K), true
+}
+
+// reset remove all keys
+func (l *lru[K]) reset() {
+	l.ll.Init()
+	l.elems = make(map[K]*list.Element)
+}
@@ -0,0 +1,184 @@
+package cache
+
+import "container/list"
+
+// arc adaptive replacement cache
+// Keys used once are in t1, keys used more than once are in t2, b1 and b2 remember the keys recently evicted
+// from t1 and t2. A hit in b1 means t1 is too small and a hit in b2 means t2 is too small, so the target size p
+// of t1 adapts to the workload. A scan of new keys only churns t1 and does not evict the frequently used keys
+type arc[K comparable] struct {
+	capacity       int
+	p              int // target size of t1
+	t1, t2, b1, b2 *list.List
+	elems          map[K]*arcEntry[K]
+
+	// the key added last, it is not evicted by the replacement it caused
+	fresh       K
+	hasFresh    bool
+	freshFromB2 bool
+}
+
+type arcList int
+
+const (
+	arcT1 arcList = iota
+	arcT2
+	arcB1
+	arcB2
+)
+
+type arcEntry[K comparable] struct {
+	key  K
+	list arcList
+	elem *list.Element
+}
+
+// newArc when the capacity is 0, the number of keys in the cache is used
+func newArc[K comparable](capacity int) *arc[K] {
+	a := &arc[K]{capacity: capacity}
+	a.reset()
+	return a
+}
+
+func (a *arc[K]) reset() {
+	a.p = 0
+	a.t1, a.t2, a.b1, a.b2 = list.New(), list.New(), list.New(), list.New()
+	a.elems = make(map[K]*arcEntry[K])
+	a.hasFresh = false
+}
+
+func (a *arc[K]) list(l arcList) *list.List {
+	switch l {
+	case arcT1:
+		return a.t1
+	case arcT2:
+		return a.t2
+	case arcB1:
+		return a.b1
+	default:
+		return a.b2
+	}
+}
+
+// move an entry to the front of the list
+func (a *arc[K]) move(e *arcEntry[K], to arcList) {
+	a.list(e.list).Remove(e.elem)
+	e.list = to
+	e.elem = a.list(to).PushFront(e)
+}
+
+// drop an entry
+func (a *arc[K]) drop(e *arcEntry[K]) {
+	a.list(e.list).Remove(e.elem)
+	delete(a.elems, e.key)
+}
+
+func (a *arc[K]) size() int {
+	if a.capacity > 0 {
+		return a.capacity
+	}
+	return a.t1.Len() + a.t2.Len()
+}
+
+func (a *arc[K]) resident(e *arcEntry[K]) bool {
+	return e.list == arcT1 || e.list == arcT2
+}
+
+func (a *arc[K]) add(key K) {
+	e, ok := a.elems[key]
+	if ok && a.resident(e) {
+		a.access(key)
+		return
+	}
+	a.fresh, a.hasFresh, a.freshFromB2 = key, true, false
+	if ok {
+		// a ghost hit, adapt the target size of t1
+		c := a.size()
+		switch e.list {
+		case arcB1:
+			a.p = minInt(c, a.p+maxInt(a.b2.Len()/a.b1.Len(), 1))
+		case arcB2:
+			a.p = maxInt(0, a.p-maxInt(a.b1.Len()/a.b2.Len(), 1))
+			a.freshFromB2 = true
+		}
+		a.move(e, arcT2)
+		return
+	}
+	e = &arcEntry[K]{key: key, list: arcT1}
+	e.elem = a.t1.PushFront(e)
+	a.elems[key] = e
+	a.trimGhosts()
+}
+
+func (a *arc[K]) access(key K) {
+	e, ok := a.elems[key]
+	if ok && a.resident(e) {
+		a.move(e, arcT2)
+	}
+}
+
+// remove the ghost is kept, so that an evicted key can still adapt the target size
+func (a *arc[K]) remove(key K) {
+	e, ok := a.elems[key]
+	if ok && a.resident(e) {
+		a.drop(e)
+	}
+}
+
+func (a *arc[K]) victim() (K, bool) {
+	t1 := a.t1.Len()
+	if a.hasFresh {
+		if e, ok := a.elems[a.fresh]; ok && e.list == arcT1 {
+			t1--
+		}
+	}
+	fromT1 := t1 > 0 && (t1 > a.p || (a.freshFromB2 && t1 == a.p))
+	elem := a.t2.Back()
+	if fromT1 || elem == nil || a.isFresh(elem) {
+		elem = a.t1.Back()
+	}
+	if elem == nil {
+		elem = a.t2.Back()
+	}
+	if elem == nil {
+		var zero K
+		return zero, false
+	}
+	e := elem.Value.(*arcEntry[K])
+	if e.list == arcT1 {
+		a.move(e, arcB1)
+	} else {
+		a.move(e, arcB2)
+	}
+	a.trimGhosts()
+	return e.key, true
+}
+
+func (a *arc[K]) isFresh(elem *list.Element) bool {
+	return a.hasFresh && elem.Value.(*arcEntry[K]).key == a.fresh
+}
+
+// keep the directory at most twice the capacity
+func (a *arc[K]) trimGhosts() {
+	c := a.size()
+	for a.b1.Len() > 0 && a.t1.Len()+a.b1.Len() > c {
+		a.drop(a.b1.Back().Value.(*arcEntry[K]))
+	}
+	for a.b2.Len() > 0 && a.t1.Len()+a.t2.Len()+a.b1.Len()+a.b2.Len() > 2*c {
+		a.drop(a.b2.Back().Value.(*arcEntry[K]))
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
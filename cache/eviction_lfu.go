@@ -0,0 +1,128 @@
+package cache
+
+import "container/heap"
+
+// lfu least frequently used with dynamic aging (LFU-DA)
+// The priority of a key is its frequency plus the age of the cache, the age is raised to the priority of
+// every evicted key. So new keys start at the current age, and keys used frequently long ago are evicted eventually
+type lfu[K comparable] struct {
+	entries lfuHeap[K]
+	elems   map[K]*lfuEntry[K]
+	age     int64
+	seq     int64 // keys with the same priority are evicted in least recently used order
+
+	// the key added last, it is not evicted by the replacement it caused
+	fresh    K
+	hasFresh bool
+}
+
+type lfuEntry[K comparable] struct {
+	key      K
+	freq     int64
+	priority int64
+	seq      int64
+	index    int
+}
+
+func newLfu[K comparable]() *lfu[K] {
+	return &lfu[K]{
+		elems: make(map[K]*lfuEntry[K]),
+	}
+}
+
+func (l *lfu[K]) add(key K) {
+	if _, ok := l.elems[key]; ok {
+		l.access(key)
+		return
+	}
+	l.seq++
+	e := &lfuEntry[K]{
+		key:      key,
+		freq:     1,
+		priority: 1 + l.age,
+		seq:      l.seq,
+	}
+	heap.Push(&l.entries, e)
+	l.elems[key] = e
+	l.fresh, l.hasFresh = key, true
+}
+
+func (l *lfu[K]) access(key K) {
+	e, ok := l.elems[key]
+	if !ok {
+		return
+	}
+	l.seq++
+	e.freq++
+	e.priority = e.freq + l.age
+	e.seq = l.seq
+	heap.Fix(&l.entries, e.index)
+}
+
+func (l *lfu[K]) remove(key K) {
+	e, ok := l.elems[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&l.entries, e.index)
+	delete(l.elems, key)
+}
+
+func (l *lfu[K]) victim() (K, bool) {
+	if len(l.entries) == 0 {
+		var zero K
+		return zero, false
+	}
+	e := l.entries[0]
+	if l.hasFresh && e.key == l.fresh && len(l.entries) > 1 {
+		// the next smallest is one of the children of the root
+		e = l.entries[1]
+		if len(l.entries) > 2 && l.entries.Less(2, 1) {
+			e = l.entries[2]
+		}
+	}
+	l.age = e.priority
+	return e.key, true
+}
+
+func (l *lfu[K]) reset() {
+	l.entries = nil
+	l.elems = make(map[K]*lfuEntry[K])
+	l.age = 0
+	l.hasFresh = false
+}
+
+// lfuHeap min heap of priority
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (h lfuHeap[K]) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x any) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,96 @@
+package cache
+
+import "container/heap"
+
+// expiryIndex min heap of the expiration time of the data
+// The data never expiring is not indexed, so gc only touches the data that is due
+type expiryIndex[K comparable] struct {
+	entries expiryHeap[K]
+	elems   map[K]*expiryEntry[K]
+}
+
+type expiryEntry[K comparable] struct {
+	key        K
+	expiration int64
+	index      int
+}
+
+// update the expiration time of a key, 0 means it never expires
+func (x *expiryIndex[K]) update(key K, expiration int64) {
+	e, ok := x.elems[key]
+	if expiration == 0 {
+		if ok {
+			x.remove(key)
+		}
+		return
+	}
+	if ok {
+		e.expiration = expiration
+		heap.Fix(&x.entries, e.index)
+		return
+	}
+	if x.elems == nil {
+		x.elems = make(map[K]*expiryEntry[K])
+	}
+	e = &expiryEntry[K]{key: key, expiration: expiration}
+	heap.Push(&x.entries, e)
+	x.elems[key] = e
+}
+
+// remove a key
+func (x *expiryIndex[K]) remove(key K) {
+	e, ok := x.elems[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&x.entries, e.index)
+	delete(x.elems, key)
+}
+
+// peek the key expiring first
+func (x *expiryIndex[K]) peek() (K, int64, bool) {
+	if len(x.entries) == 0 {
+		var zero K
+		return zero, 0, false
+	}
+	e := x.entries[0]
+	return e.key, e.expiration, true
+}
+
+// reset remove all keys
+func (x *expiryIndex[K]) reset() {
+	x.entries = nil
+	x.elems = nil
+}
+
+// expiryHeap min heap of expiration time
+type expiryHeap[K comparable] []*expiryEntry[K]
+
+func (h expiryHeap[K]) Len() int {
+	return len(h)
+}
+
+func (h expiryHeap[K]) Less(i, j int) bool {
+	return h[i].expiration < h[j].expiration
+}
+
+func (h expiryHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K]) Push(x any) {
+	e := x.(*expiryEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
@@ -1,10 +1,45 @@
 package cache
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Interface[E any] interface {
+	KeyInterface[string, E]
+}
+
+type MapInterface[E any] interface {
+	KeyMapInterface[string, E]
+
+	// DeletePrefix  delete all data whose key starts with the prefix, and return the number of data deleted
+	DeletePrefix(prefix string) int
+}
+
+// Backend the second level of TieredCache, usually larger and slower than the cache
+type Backend[E any] interface {
+	// Get get data by key, and whether it exists
+	Get(ctx context.Context, key string) (E, bool, error)
+	// Set set data by key, it expires after ttl, 0 means it never expires
+	Set(ctx context.Context, key string, value E, ttl time.Duration) error
+	// Delete delete data by key, deleting data that does not exist is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Transport the bus carrying invalidations between the caches of Broadcaster
+type Transport interface {
+	// Send send the message to all peers, the sender may receive it too
+	Send(msg []byte) error
+	// Receive wait for the next message from the peers, it returns an error after Close
+	Receive() ([]byte, error)
+	// Close close the transport
+	Close() error
+}
+
+// KeyInterface the keys can be of any comparable type
+type KeyInterface[K comparable, E any] interface {
 	// IsExpired judge whether the data is expired
-	IsExpired(key string) (bool, error)
+	IsExpired(key K) (bool, error)
 	// DeleteExpired delete all expired data
 	DeleteExpired()
 
@@ -16,31 +51,98 @@ type Interface[E any] interface {
 
 	// Get  data
 	// When the data does not exist or expires, it will return nonexistence（false）
-	Get(key string) (E, bool)
+	Get(key K) (E, bool)
 	// GetAndDelete get data and delete by key
-	GetAndDelete(key string) (E, bool)
+	GetAndDelete(key K) (E, bool)
 	// GetAndExpired  get data and expire by key
 	// It will be deleted at the next clearing. If the clearing capability is not enabled, it will never be deleted
-	GetAndExpired(key string) (E, bool)
+	GetAndExpired(key K) (E, bool)
 	// GetWithExpiration get expiration time
-	GetWithExpiration(key string) (E, time.Time, bool)
+	// With sliding expiration, the expiration time is extended by reading, and the extended one is returned
+	GetWithExpiration(key K) (E, time.Time, bool)
 
 	// Delete delete data by key
-	Delete(key string) (E, bool)
+	Delete(key K) (E, bool)
 }
 
-type MapInterface[E any] interface {
-	Interface[E]
+// KeyMapInterface the keys can be of any comparable type
+type KeyMapInterface[K comparable, E any] interface {
+	KeyInterface[K, E]
 
 	// Set  data by key，it will overwrite the data if the key exists
-	Set(key string, value E)
+	Set(key K, value E)
 	// SetDefault  data by key，it will overwrite the data if the key exists
-	SetDefault(key string, value E, expiration time.Duration)
+	SetDefault(key K, value E, expiration time.Duration)
+	// SetSliding  data by key with sliding expiration，it will overwrite the data if the key exists
+	// The data expires when it is not read for the idle timeout, every read extends the expiration time
+	SetSliding(key K, value E, idle time.Duration)
+	// SetWithCost  data by key with the cost of the data，it will overwrite the data if the key exists
+	// The cost is not persisted, the data loaded from the persistence file is costed by the cost function
+	SetWithCost(key K, value E, cost int64)
 	// Add data，Cannot add existing data
 	// To override the addition, use the set method
-	Add(key string, value E) error
+	Add(key K, value E) error
+	// GetMany  get data by keys under one lock
+	// The data that does not exist or expires is not in the result
+	GetMany(keys []K) map[K]E
+	// SetMany  set data under one lock，it will overwrite the data if the key exists
+	// The data expires after ttl. When ttl is 0 the default expiration time is used, when it is DefaultExpiration
+	// the data never expires
+	SetMany(items map[K]E, ttl time.Duration)
+	// DeleteMany  delete data by keys under one lock, and return the number of data deleted
+	DeleteMany(keys []K) int
+	// SetWithTags  data by key with tags，it will overwrite the data if the key exists
+	// The data expires after ttl. When ttl is 0 the default expiration time is used, when it is DefaultExpiration
+	// the data never expires. The data can be deleted by any of its tags with InvalidateTag
+	SetWithTags(key K, value E, ttl time.Duration, tags ...string)
+	// InvalidateTag  delete all data with the tag, and return the number of data deleted
+	InvalidateTag(tag string) int
+	// Update  update data by key atomically
+	// fn gets the current data and whether it exists, and returns the new data and whether to store it.
+	// When the data exists its expiration time is kept, otherwise the default expiration time is used.
+	// It returns the data in the cache afterwards and whether it exists. fn is called while holding the lock, so it must not use the cache
+	Update(key K, fn func(old E, exists bool) (E, bool)) (E, bool)
+	// CompareAndSwap  set data by key to new if it is old atomically, and return whether it is swapped
+	// The expiration time of the data is kept
+	CompareAndSwap(key K, old, new E) bool
+	// CompareAndDelete  delete data by key if it is old atomically, and return whether it is deleted
+	CompareAndDelete(key K, old E) bool
+	// GetOrSet  get data by key, and set it if it does not exist or expires
+	// It returns the data in the cache, and true if it exists before
+	GetOrSet(key K, value E) (E, bool)
+	// Replace  set data by key only if it exists, and return whether it is set
+	Replace(key K, value E) bool
+	// GetOrLoad get data, and load it by the loader when it does not exist or expires
+	// Without a loader, the loader configured by SetLoader is used. Concurrent misses on a key are coalesced into a
+	// single load, the loaded data is set with the default expiration time and the error of the loader is returned
+	GetOrLoad(key K, loader func(key K) (E, error)) (E, error)
 	// Clear remove all data
 	Clear()
-	// Keys get all keys
-	Keys() []string
+	// Keys get all keys, the expired data is skipped
+	Keys() []K
+	// Len get the number of data, the expired data is not counted
+	Len() int
+	// Items get a copy of all data with the expiration time, the expired data is skipped
+	Items() map[K]Item[E]
+	// Range call fn for all data until it returns false, the expired data is skipped
+	// fn is called on a copy of the data outside the lock, so it can use the cache
+	Range(fn func(key K, value E) bool)
+	// Cost get the total cost of all data in memory, the data spilled to the overflow store costs nothing
+	Cost() int64
+	// Stats get the statistics, all zero when they are not enabled
+	Stats() Stats
+	// Subscribe  subscribe the changes of the data, and return the events and the function to cancel the subscription
+	// filter chooses the events, nil means all events. It must not use the cache. The events are received in the order
+	// of the changes, the channel is closed when the subscription is canceled or the cache is closed
+	Subscribe(filter func(e Event[K, E]) bool) (<-chan Event[K, E], func())
+	// RewriteAof rewrite the append only file now, it returns when the new file replaces the old one
+	// The writers are not blocked meanwhile. It returns an error if AOF is not enabled or a rewrite is in progress
+	RewriteAof() error
+
+	// Flush write the data to the persistence file now
+	// With FFB a snapshot is written, with AOF the file is fsynced
+	Flush() error
+	// Close stop gc and persistence, and write the data to the persistence file for the last time
+	// After closing, operations returning an error return ErrClosed, the others behave as if the cache is empty
+	Close() error
 }
@@ -5,8 +5,14 @@ import (
 )
 
 type Item[E any] struct {
-	Object     E     // data
-	Expiration int64 // expiration time
+	Object     E        // data
+	Expiration int64    // expiration time
+	cost       int64    // cost of data, it is not persisted
+	written    int64    // write time, it is not persisted, 0 means unknown
+	idle       int64    // idle timeout of sliding expiration, it is not persisted, 0 means the expiration time is fixed
+	tags       []string // tags of data, they are persisted beside the item
+	disk       *diskLoc // where data is in the overflow store, nil when it is not written there
+	cold       bool     // data is spilled to the overflow store, Object is not in memory
 }
 
 // judge whether data is expired
@@ -11,12 +11,41 @@ const (
 
 	// DefaultPersistencePath default persistence path
 	DefaultPersistencePath = "/val/cache/persistence"
+
+	// DefaultAofRewriteMinSize the append only file is not rewritten automatically until it reaches 64MB
+	DefaultAofRewriteMinSize int64 = 64 << 20
+
+	// DefaultAofRewritePercentage the append only file is rewritten automatically when it doubles in size
+	DefaultAofRewritePercentage = 100
+
+	// DefaultSnapshotInterval Default snapshot interval of FFB is five seconds
+	DefaultSnapshotInterval = time.Second * 5
+
+	// DefaultOverflowSegmentSize a new segment of the overflow store is started when the active one reaches 64MB
+	DefaultOverflowSegmentSize int64 = 64 << 20
+
+	// DefaultShards Default number of shards of the sharded cache
+	DefaultShards = 32
+
+	// DefaultEventBuffer Default number of events buffered for a subscriber
+	DefaultEventBuffer = 256
+
+	// DefaultWriteQueue Default number of writes of L2 queued by the tiered cache with WriteBehind
+	DefaultWriteQueue = 1024
+)
+
+const (
+	// gc deletes at most this many data items while holding the lock
+	gcBatchSize = 1024
+	// gc releases the lock after this duration, even if the batch is not full
+	gcBatchTime = time.Millisecond
 )
 
 // expiration policy
 type expirationOption struct {
-	expiration time.Duration // Expiration time
-	gcInterval time.Duration // Overdue data Item cleaning cycle
+	expiration        time.Duration // Expiration time
+	slidingExpiration time.Duration // idle timeout, every read extends the expiration time by it
+	gcInterval        time.Duration // Overdue data Item cleaning cycle
 }
 
 // persistencePolicy policy
@@ -25,11 +54,69 @@ type persistenceOption struct {
 	enablePersistence bool        // enable persistencePolicy
 	persistencePolicy Persistence // persistencePolicy policy
 	persistencePath   string      // persistencePath
+	aofFsync          AofFsync    // fsync policy of the append only file
+	codec             Codec       // codec of the persistence files
+
+	aofRewriteMinSize    int64 // minimum size of the append only file to rewrite automatically
+	aofRewritePercentage int   // growth percentage of the append only file to rewrite automatically
+
+	snapshotInterval time.Duration  // a snapshot is written at this interval if the data has changed
+	snapshotRules    []snapshotRule // a snapshot is written when one of the rules is satisfied
+
+	overflowSegmentSize int64 // size of a segment of the overflow store
+}
+
+// snapshotRule write a snapshot when there are at least changes within the duration since the last snapshot
+type snapshotRule struct {
+	within  time.Duration
+	changes int64
+}
+
+// eviction policy
+type evictionOption struct {
+	maxEntries     int            // maximum number of data items, 0 means unlimited
+	maxCost        int64          // maximum total cost of data items, 0 means unlimited
+	costFunc       any            // func(E) int64, cost of a data item
+	evictionPolicy EvictionPolicy // which data is evicted when the cache is full
+	onEvicted      any            // func(K, E, EvictReason), called when the data leaves the cache
+}
+
+// shard policy
+type shardOption struct {
+	shards int // number of shards of the sharded cache
+}
+
+// load policy
+type loaderOption struct {
+	loader      any           // func(K) (E, error), load the data missing from the cache
+	negativeTTL time.Duration // errors of the loader are cached for this duration, 0 means not cached
+
+	refreshAfter time.Duration // the data is reloaded in the background when it is read this long after writing
+	staleGrace   time.Duration // the expired data is still served this long while it is reloaded
+}
+
+// event policy
+type eventOption struct {
+	eventBuffer   int            // number of events buffered for a subscriber
+	eventOverflow OverflowPolicy // what happens when the buffer of a subscriber is full
+}
+
+// tiered policy
+type tieredOption struct {
+	l2Expiration time.Duration // expiration time of L2, 0 means never expires
+	writeMode    WriteMode     // how L2 is written
+	writeQueue   int           // number of writes of L2 queued with WriteBehind
 }
 
 type options struct {
 	expirationOption
 	persistenceOption
+	evictionOption
+	shardOption
+	loaderOption
+	eventOption
+	tieredOption
+	enableStats bool // enable statistics
 }
 
 func newOption() options {
@@ -42,7 +129,32 @@ func newOption() options {
 			enablePersistence: false,
 			persistencePolicy: FFB,
 			persistencePath:   DefaultPersistencePath,
+			aofFsync:          FsyncEverySec,
+			codec:             GobCodec,
+
+			aofRewriteMinSize:    DefaultAofRewriteMinSize,
+			aofRewritePercentage: DefaultAofRewritePercentage,
+
+			snapshotInterval: DefaultSnapshotInterval,
+
+			overflowSegmentSize: DefaultOverflowSegmentSize,
+		},
+		evictionOption{
+			evictionPolicy: LRU,
+		},
+		shardOption{
+			shards: DefaultShards,
+		},
+		loaderOption{},
+		eventOption{
+			eventBuffer:   DefaultEventBuffer,
+			eventOverflow: OverflowDrop,
 		},
+		tieredOption{
+			writeMode:  WriteThrough,
+			writeQueue: DefaultWriteQueue,
+		},
+		false,
 	}
 }
 
@@ -57,6 +169,15 @@ func SetExpirationTime(expiration time.Duration) CreateOptionFunc {
 	}
 }
 
+// SetSlidingExpiration  set sliding expiration time
+// The data expires when it is not read for the idle timeout, every read extends the expiration time.
+// It overrides SetExpirationTime for the data set without an expiration time
+func SetSlidingExpiration(idle time.Duration) CreateOptionFunc {
+	return func(o *options) {
+		o.slidingExpiration = idle
+	}
+}
+
 // SetGcInterval  set gc interval
 // When the cleaning cycle is 0, it is automatically adjusted to 1 minute
 func SetGcInterval(gcInterval time.Duration) CreateOptionFunc {
@@ -89,3 +210,184 @@ func SetPersistencePath(path string) CreateOptionFunc {
 		o.persistencePath = path
 	}
 }
+
+// SetAofFsync  set fsync policy of the append only file,default fsync policy is FsyncEverySec
+// It only takes effect when the persistence policy is AOF
+func SetAofFsync(fsync AofFsync) CreateOptionFunc {
+	return func(o *options) {
+		o.aofFsync = fsync
+	}
+}
+
+// SetAofRewrite  set when the append only file is rewritten automatically
+// The file is rewritten when it is bigger than minSize and has grown by percentage since the last rewrite
+// When the percentage is 0, automatic rewriting is disabled
+func SetAofRewrite(minSize int64, percentage int) CreateOptionFunc {
+	return func(o *options) {
+		o.aofRewriteMinSize = minSize
+		o.aofRewritePercentage = percentage
+	}
+}
+
+// SetCodec  set codec of the persistence files,default codec is GobCodec
+// Built-in codecs are GobCodec, JSONCodec and BinaryCodec, files written by any of them can always be read
+func SetCodec(codec Codec) CreateOptionFunc {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// SetSnapshotInterval  set snapshot interval of FFB,default snapshot interval is DefaultSnapshotInterval
+// The snapshot is skipped when nothing has changed, when the interval is 0, only the snapshot rules are applied
+func SetSnapshotInterval(interval time.Duration) CreateOptionFunc {
+	return func(o *options) {
+		o.snapshotInterval = interval
+	}
+}
+
+// SetSnapshotRule  write a snapshot of FFB when at least changes have been made and the duration has passed since the last snapshot
+// Like the save rules of Redis, it can be set multiple times, and a snapshot is written when any of the rules is satisfied
+func SetSnapshotRule(within time.Duration, changes int) CreateOptionFunc {
+	return func(o *options) {
+		o.snapshotRules = append(o.snapshotRules, snapshotRule{
+			within:  within,
+			changes: int64(changes),
+		})
+	}
+}
+
+// SetOverflowSegmentSize  set the size of a segment of the overflow store,default is 64MB
+// The checkpoints of the overflow store are written like the snapshots of FFB, by SetSnapshotInterval and SetSnapshotRule
+func SetOverflowSegmentSize(size int64) CreateOptionFunc {
+	return func(o *options) {
+		o.overflowSegmentSize = size
+	}
+}
+
+// SetMaxEntries  set maximum number of data items,default is 0 (unlimited)
+// When the cache is full, the data chosen by the eviction policy is evicted
+func SetMaxEntries(maxEntries int) CreateOptionFunc {
+	return func(o *options) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// SetEvictionPolicy  set eviction policy,default eviction policy is LRU
+// It only takes effect when the capacity of the cache is limited
+func SetEvictionPolicy(policy EvictionPolicy) CreateOptionFunc {
+	return func(o *options) {
+		o.evictionPolicy = policy
+	}
+}
+
+// SetMaxCost  set maximum total cost of data items,default is 0 (unlimited)
+// When the total cost exceeds it, the data chosen by the eviction policy is evicted until it fits
+// A data item costing more than the maximum is dropped at once without evicting the others
+func SetMaxCost(maxCost int64) CreateOptionFunc {
+	return func(o *options) {
+		o.maxCost = maxCost
+	}
+}
+
+// SetCostFunc  set the cost function of data items, such as the size of the value in bytes
+// The type of the value must be the same as the cache. Without a cost function, every data item costs 1
+func SetCostFunc[E any](costFunc func(value E) int64) CreateOptionFunc {
+	return func(o *options) {
+		o.costFunc = costFunc
+	}
+}
+
+// OnEvicted  set the function called when the data leaves the cache, with the reason why it left
+// The types of the key and the value must be the same as the cache. It is called outside the lock, so it can use the cache
+func OnEvicted[K comparable, E any](onEvicted func(key K, value E, reason EvictReason)) CreateOptionFunc {
+	return func(o *options) {
+		o.onEvicted = onEvicted
+	}
+}
+
+// SetShards  set the number of shards of the sharded cache,default is 32
+// It only works with NewShardedMapCache
+func SetShards(shards int) CreateOptionFunc {
+	return func(o *options) {
+		o.shards = shards
+	}
+}
+
+// SetLoader  set the function loading the data missing from the cache, it is used by GetOrLoad without a loader
+// The types of the key and the value must be the same as the cache
+func SetLoader[K comparable, E any](loader func(key K) (E, error)) CreateOptionFunc {
+	return func(o *options) {
+		o.loader = loader
+	}
+}
+
+// SetNegativeTTL  set how long an error of the loader is cached,default is 0 (not cached)
+// Within the duration, GetOrLoad returns the error without loading again
+func SetNegativeTTL(negativeTTL time.Duration) CreateOptionFunc {
+	return func(o *options) {
+		o.negativeTTL = negativeTTL
+	}
+}
+
+// SetRefreshAfter  set how long after writing the data is reloaded,default is 0 (not reloaded)
+// The first read after it reloads the data by the loader in the background, and the current data is served meanwhile.
+// It only works with a loader configured by SetLoader
+func SetRefreshAfter(refreshAfter time.Duration) CreateOptionFunc {
+	return func(o *options) {
+		o.refreshAfter = refreshAfter
+	}
+}
+
+// SetStaleGrace  set how long the expired data is still served,default is 0 (not served)
+// Reading the expired data within it reloads the data in the background, the expired data is served until the reload
+// succeeds or the window ends. It only works with a loader configured by SetLoader
+func SetStaleGrace(staleGrace time.Duration) CreateOptionFunc {
+	return func(o *options) {
+		o.staleGrace = staleGrace
+	}
+}
+
+// SetEnableStats  enable statistics,default is false
+// The counters are updated atomically without the lock, and got by Stats
+func SetEnableStats(enable bool) CreateOptionFunc {
+	return func(o *options) {
+		o.enableStats = enable
+	}
+}
+
+// SetEventBuffer  set the number of events buffered for a subscriber,default is 256
+func SetEventBuffer(size int) CreateOptionFunc {
+	return func(o *options) {
+		o.eventBuffer = size
+	}
+}
+
+// SetEventOverflow  set what happens when the buffer of a subscriber is full,default is OverflowDrop
+func SetEventOverflow(policy OverflowPolicy) CreateOptionFunc {
+	return func(o *options) {
+		o.eventOverflow = policy
+	}
+}
+
+// SetL2Expiration  set the expiration time of L2 of the tiered cache,default is 0 (never expires)
+// The expiration time of L1 set by SetExpirationTime must be shorter than it
+func SetL2Expiration(expiration time.Duration) CreateOptionFunc {
+	return func(o *options) {
+		o.l2Expiration = expiration
+	}
+}
+
+// SetWriteMode  set how the tiered cache writes L2,default is WriteThrough
+func SetWriteMode(mode WriteMode) CreateOptionFunc {
+	return func(o *options) {
+		o.writeMode = mode
+	}
+}
+
+// SetWriteQueue  set the number of writes of L2 queued with WriteBehind,default is 1024
+// The writes wait when the queue is full
+func SetWriteQueue(size int) CreateOptionFunc {
+	return func(o *options) {
+		o.writeQueue = size
+	}
+}
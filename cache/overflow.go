@@ -0,0 +1,574 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// OverflowDirSUFFIX suffix of the directory of the overflow store
+	OverflowDirSUFFIX = "_overflow"
+	// the log segments are named by their number
+	overflowSegmentSUFFIX = ".seg"
+	// the index of the data in the segments
+	overflowIndexFile = "index"
+)
+
+// index file format: magic(4 bytes) version(1 byte) codec length(1 byte) codec name payload length(8 bytes) crc32(4 bytes) payload
+var overflowIndexMagic = []byte("GUCI")
+
+const overflowIndexVersion = 1
+
+// diskLoc where the data is in the overflow store
+type diskLoc struct {
+	segment uint32
+	offset  int64
+	size    int64
+}
+
+// overflowRecord a record of a segment
+type overflowRecord[K comparable, E any] struct {
+	Key    K
+	Object E
+}
+
+// overflowIndexEntry an entry of the index file
+type overflowIndexEntry[K comparable] struct {
+	Key        K
+	Segment    uint32
+	Offset     int64
+	Size       int64
+	Expiration int64
+	Tags       []string `json:",omitempty"`
+}
+
+// a log segment
+type segment struct {
+	file    *os.File
+	size    int64 // bytes written
+	garbage int64 // bytes of the records no longer used
+}
+
+// overflowStore log-structured store of the data spilled from memory
+// Records are appended to the active segment, a new segment is started when it is full. The overwritten and
+// deleted records are garbage, the segments mostly garbage are compacted by moving their live records.
+// The store is protected by the lock of the cache, reading takes the read lock, the others take the write lock
+type overflowStore[K comparable, E any] struct {
+	dir         string
+	codec       Codec
+	segmentSize int64
+	segments    map[uint32]*segment
+	active      uint32
+}
+
+// open the overflow store, and load the data in the index as spilled data
+// The segments are not trusted without the index, so they are removed
+func openOverflowStore[K comparable, E any](dir string, codec Codec, segmentSize int64) (*overflowStore[K, E], map[K]*Item[E], error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &overflowStore[K, E]{
+		dir:         dir,
+		codec:       codec,
+		segmentSize: segmentSize,
+		segments:    make(map[uint32]*segment),
+	}
+	ids, err := s.segmentIds()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range ids {
+		f, err := os.OpenFile(s.segmentFile(id), os.O_RDWR, 0644)
+		if err != nil {
+			s.close()
+			return nil, nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			s.close()
+			return nil, nil, err
+		}
+		// every byte is garbage until the index refers to it
+		s.segments[id] = &segment{file: f, size: info.Size(), garbage: info.Size()}
+		s.active = id
+	}
+
+	items := make(map[K]*Item[E])
+	entries, err := s.readIndex()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("cache: overflow index %s is corrupt (%v), the spilled data is dropped", s.indexFile(), err)
+	}
+	for _, e := range entries {
+		seg, ok := s.segments[e.Segment]
+		if !ok || e.Offset+e.Size > seg.size {
+			continue
+		}
+		seg.garbage -= e.Size
+		items[e.Key] = &Item[E]{
+			Expiration: e.Expiration,
+			tags:       e.Tags,
+			disk:       &diskLoc{segment: e.Segment, offset: e.Offset, size: e.Size},
+			cold:       true,
+		}
+	}
+	for id, seg := range s.segments {
+		if seg.garbage == seg.size {
+			err = s.removeSegment(id)
+			if err != nil {
+				s.close()
+				return nil, nil, err
+			}
+		}
+	}
+	// appending starts in a new segment, the tail of the last one may be damaged
+	err = s.rotate()
+	if err != nil {
+		s.close()
+		return nil, nil, err
+	}
+	return s, items, nil
+}
+
+func (s *overflowStore[K, E]) segmentFile(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%010d%s", id, overflowSegmentSUFFIX))
+}
+
+func (s *overflowStore[K, E]) indexFile() string {
+	return filepath.Join(s.dir, overflowIndexFile)
+}
+
+// numbers of the segment files in order
+func (s *overflowStore[K, E]) segmentIds() ([]uint32, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, overflowSegmentSUFFIX) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, overflowSegmentSUFFIX), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+// start a new active segment
+func (s *overflowStore[K, E]) rotate() error {
+	if seg, ok := s.segments[s.active]; ok {
+		err := seg.file.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	id := s.active + 1
+	f, err := os.OpenFile(s.segmentFile(id), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	s.segments[id] = &segment{file: f}
+	s.active = id
+	return nil
+}
+
+// append the data to the active segment
+func (s *overflowStore[K, E]) append(key K, value E) (*diskLoc, error) {
+	if s.segments[s.active].size >= s.segmentSize {
+		err := s.rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	payload, err := s.codec.Marshal(&overflowRecord[K, E]{Key: key, Object: value})
+	if err != nil {
+		return nil, err
+	}
+	frame := encodeFrame(payload)
+	seg := s.segments[s.active]
+	_, err = seg.file.WriteAt(frame, seg.size)
+	if err != nil {
+		return nil, err
+	}
+	loc := &diskLoc{segment: s.active, offset: seg.size, size: int64(len(frame))}
+	seg.size += loc.size
+	return loc, nil
+}
+
+// read the data of the key back, a record of another key means the index is stale and is treated as corruption
+func (s *overflowStore[K, E]) read(key K, loc *diskLoc) (E, error) {
+	var zero E
+	seg, ok := s.segments[loc.segment]
+	if !ok {
+		return zero, fmt.Errorf("segment %d is missing", loc.segment)
+	}
+	frame := make([]byte, loc.size)
+	_, err := seg.file.ReadAt(frame, loc.offset)
+	if err != nil {
+		return zero, err
+	}
+	if loc.size < aofFrameHeader || int64(binary.BigEndian.Uint32(frame[0:4])) != loc.size-aofFrameHeader {
+		return zero, errors.New("record length mismatch")
+	}
+	payload := frame[aofFrameHeader:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(frame[4:8]) {
+		return zero, errors.New("record checksum mismatch")
+	}
+	var record overflowRecord[K, E]
+	err = s.codec.Unmarshal(payload, &record)
+	if err != nil {
+		return zero, err
+	}
+	if record.Key != key {
+		return zero, fmt.Errorf("record of %v is found instead", record.Key)
+	}
+	return record.Object, nil
+}
+
+// the record is no longer used
+func (s *overflowStore[K, E]) discard(loc *diskLoc) {
+	if seg, ok := s.segments[loc.segment]; ok {
+		seg.garbage += loc.size
+	}
+}
+
+// the segments to compact, at least half of them is garbage
+func (s *overflowStore[K, E]) garbageSegments() map[uint32]bool {
+	var res map[uint32]bool
+	for id, seg := range s.segments {
+		if id == s.active || seg.garbage*2 < seg.size {
+			continue
+		}
+		if res == nil {
+			res = make(map[uint32]bool)
+		}
+		res[id] = true
+	}
+	return res
+}
+
+// start a new active segment if the active one is mostly garbage, so it can be compacted too
+func (s *overflowStore[K, E]) retire() error {
+	seg := s.segments[s.active]
+	if seg.size == 0 || seg.garbage*2 < seg.size {
+		return nil
+	}
+	return s.rotate()
+}
+
+func (s *overflowStore[K, E]) removeSegment(id uint32) error {
+	seg := s.segments[id]
+	delete(s.segments, id)
+	_ = seg.file.Close()
+	err := os.Remove(s.segmentFile(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// remove all segments
+func (s *overflowStore[K, E]) clear() error {
+	for id := range s.segments {
+		err := s.removeSegment(id)
+		if err != nil {
+			return err
+		}
+	}
+	return s.rotate()
+}
+
+func (s *overflowStore[K, E]) sync() error {
+	return s.segments[s.active].file.Sync()
+}
+
+func (s *overflowStore[K, E]) close() {
+	for _, seg := range s.segments {
+		_ = seg.file.Close()
+	}
+	s.segments = nil
+}
+
+// write the index file atomically
+func (s *overflowStore[K, E]) writeIndex(entries []overflowIndexEntry[K]) error {
+	payload, err := s.codec.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	name := s.codec.Name()
+	data := make([]byte, 0, len(overflowIndexMagic)+2+len(name)+12+len(payload))
+	data = append(data, overflowIndexMagic...)
+	data = append(data, overflowIndexVersion, byte(len(name)))
+	data = append(data, name...)
+	data = append(data, make([]byte, 12)...)
+	binary.BigEndian.PutUint64(data[len(data)-12:], uint64(len(payload)))
+	binary.BigEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(payload))
+	data = append(data, payload...)
+
+	file := s.indexFile()
+	tmp := file + tmpFileSUFFIX
+	err = writeFileSync(tmp, data)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// read and verify the index file
+func (s *overflowStore[K, E]) readIndex() ([]overflowIndexEntry[K], error) {
+	data, err := os.ReadFile(s.indexFile())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, overflowIndexMagic) || len(data) < len(overflowIndexMagic)+2 {
+		return nil, errors.New("bad header")
+	}
+	data = data[len(overflowIndexMagic):]
+	if data[0] != overflowIndexVersion {
+		return nil, fmt.Errorf("unknown version %d", data[0])
+	}
+	if len(data) < 2+int(data[1])+12 {
+		return nil, errors.New("truncated header")
+	}
+	codec, err := findCodec(string(data[2:2+data[1]]), s.codec)
+	if err != nil {
+		return nil, err
+	}
+	data = data[2+data[1]:]
+	payload := data[12:]
+	if binary.BigEndian.Uint64(data[0:8]) != uint64(len(payload)) {
+		return nil, errors.New("truncated payload")
+	}
+	if binary.BigEndian.Uint32(data[8:12]) != crc32.ChecksumIEEE(payload) {
+		return nil, errors.New("checksum mismatch")
+	}
+	var entries []overflowIndexEntry[K]
+	err = codec.Unmarshal(payload, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// directory of the overflow store
+func (persistence *persistenceOption) overflowDir() string {
+	return filepath.Join(persistence.persistencePath, fmt.Sprintf("%s%s", persistence.persistenceName, OverflowDirSUFFIX))
+}
+
+// start the overflow store, the data in its index is loaded as spilled data
+func (c *mapCache[K, E]) startOverflow() error {
+	if c.maxEntries <= 0 && c.maxCost <= 0 {
+		return errors.New("the overflow store needs SetMaxEntries or SetMaxCost to limit the data in memory")
+	}
+	store, items, err := openOverflowStore[K, E](c.overflowDir(), c.codec, c.overflowSegmentSize)
+	if err != nil {
+		return err
+	}
+	c.disk = store
+	c.items = items
+	c.lastSave = time.Now()
+	c.stopPersistence = make(chan struct{})
+	c.persistenceDone = make(chan struct{})
+	go c.backup()
+	return nil
+}
+
+// write the data in memory to the overflow store and write the index, c.mu must not be held
+// The data stays in memory. The garbage of the deletes, overwrites and expiry is compacted first, spilling only
+// compacts the segments it fills
+func (c *mapCache[K, E]) checkpoint() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disk == nil {
+		return nil
+	}
+	err := c.disk.retire()
+	if err != nil {
+		return err
+	}
+	err = c.compact()
+	if err != nil {
+		return err
+	}
+	for k, item := range c.items {
+		if item.disk != nil {
+			continue
+		}
+		loc, err := c.disk.append(k, item.Object)
+		if err != nil {
+			return err
+		}
+		item.disk = loc
+	}
+	err = c.disk.sync()
+	if err != nil {
+		return err
+	}
+	err = c.writeOverflowIndex()
+	if err != nil {
+		return err
+	}
+	c.dirty = 0
+	c.lastSave = time.Now()
+	return nil
+}
+
+// write the index of the data in the overflow store, c.mu must be held
+func (c *mapCache[K, E]) writeOverflowIndex() error {
+	entries := make([]overflowIndexEntry[K], 0, len(c.items))
+	for k, item := range c.items {
+		if item.disk == nil {
+			continue
+		}
+		entries = append(entries, overflowIndexEntry[K]{
+			Key:        k,
+			Segment:    item.disk.segment,
+			Offset:     item.disk.offset,
+			Size:       item.disk.size,
+			Expiration: item.Expiration,
+			Tags:       item.tags,
+		})
+	}
+	return c.disk.writeIndex(entries)
+}
+
+// write the data to the overflow store and drop it from memory, c.mu must be held for writing
+func (c *mapCache[K, E]) spill(key K) {
+	item := c.items[key]
+	if item.disk == nil {
+		loc, err := c.disk.append(key, item.Object)
+		if err != nil {
+			log.Printf("cache: spill %v to the overflow store failed: %v", key, err)
+			c.del(key, ReasonEvicted)
+			return
+		}
+		item.disk = loc
+	}
+	c.evictor.remove(key)
+	var zero E
+	item.Object = zero
+	item.cold = true
+	c.totalCost -= item.cost
+	item.cost = 0
+	c.resident--
+	err := c.compact()
+	if err != nil {
+		log.Printf("cache: compact the overflow store %s failed: %v", c.overflowDir(), err)
+	}
+}
+
+// read the spilled data back into memory, and return whether it succeeds, c.mu must be held for writing
+// The data that cannot be read back is deleted
+func (c *mapCache[K, E]) restore(key K, item *Item[E]) bool {
+	value, err := c.disk.read(key, item.disk)
+	if err != nil {
+		log.Printf("cache: read %v from the overflow store failed: %v", key, err)
+		c.del(key, ReasonDeleted)
+		return false
+	}
+	cost := c.costOf(value)
+	if c.maxCost > 0 && cost > c.maxCost {
+		// it never fits, e.g. the cost function or the maximum has changed since it was spilled
+		c.del(key, ReasonEvicted)
+		return false
+	}
+	// make room first, so the data read back is not spilled at once
+	for (c.maxEntries > 0 && c.resident >= c.maxEntries) || (c.maxCost > 0 && c.totalCost+cost > c.maxCost) {
+		victim, ok := c.evictor.victim()
+		if !ok {
+			break
+		}
+		c.spill(victim)
+	}
+	item.Object = value
+	item.cold = false
+	item.cost = cost
+	c.totalCost += cost
+	c.resident++
+	c.evictor.add(key)
+	return true
+}
+
+// the data of an item, and whether it can be read
+// The spilled data is read without bringing it back into memory, the data that cannot be read back is logged
+// and should be skipped or deleted by the caller
+func (c *mapCache[K, E]) object(key K, item *Item[E]) (E, bool) {
+	if !item.cold {
+		return item.Object, true
+	}
+	value, err := c.disk.read(key, item.disk)
+	if err != nil {
+		log.Printf("cache: read %v from the overflow store failed: %v", key, err)
+		return value, false
+	}
+	return value, true
+}
+
+// move the live records out of the segments mostly garbage, c.mu must be held for writing
+// The index is written before the segments are removed, so it never refers to a removed segment
+func (c *mapCache[K, E]) compact() error {
+	ids := c.disk.garbageSegments()
+	if len(ids) == 0 {
+		return nil
+	}
+	for k, item := range c.items {
+		if item.disk == nil || !ids[item.disk.segment] {
+			continue
+		}
+		value := item.Object
+		if item.cold {
+			var err error
+			value, err = c.disk.read(k, item.disk)
+			if err != nil {
+				log.Printf("cache: read %v from the overflow store failed: %v", k, err)
+				c.del(k, ReasonDeleted)
+				continue
+			}
+		}
+		loc, err := c.disk.append(k, value)
+		if err != nil {
+			return err
+		}
+		c.disk.discard(item.disk)
+		item.disk = loc
+	}
+	err := c.disk.sync()
+	if err != nil {
+		return err
+	}
+	err = c.writeOverflowIndex()
+	if err != nil {
+		return err
+	}
+	for id := range ids {
+		err = c.disk.removeSegment(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,8 +1,13 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"log"
 	"os"
 	"path/filepath"
 	"time"
@@ -10,6 +15,29 @@ import (
 
 const FileSUFFIX = "_ffb.cdb"
 
+const (
+	// PrevFileSUFFIX suffix of the previous generation of the snapshot
+	PrevFileSUFFIX = ".prev"
+	// the snapshot is written to a temporary file and renamed into place
+	tmpFileSUFFIX = ".tmp"
+)
+
+// ErrCorruptSnapshot no good generation of the snapshot can be loaded
+var ErrCorruptSnapshot = errors.New("snapshot is corrupt")
+
+// snapshot file format: magic(4 bytes) version(1 byte) codec length(1 byte) codec name payload length(8 bytes) crc32(4 bytes) payload
+// Files without the header are written by the earlier versions of the package, they are a gob encoded map
+var snapshotMagic = []byte("GUCS")
+
+const snapshotVersion = 1
+
+// snapshotEntry an entry of the snapshot
+type snapshotEntry[K comparable, E any] struct {
+	Key  K
+	Item *Item[E]
+	Tags []string `json:",omitempty"`
+}
+
 // Persistence  policy
 type Persistence int
 
@@ -17,83 +45,363 @@ const (
 	// FFB Full File Backup
 	FFB Persistence = iota
 	// AOF Append Only File
-	//AOF
+	AOF
+	// Overflow the data beyond the limit of SetMaxEntries or SetMaxCost is spilled to a log-structured store
+	// instead of being evicted, and read back on demand. The data in memory is written to it at checkpoints
+	Overflow
 )
 
-func (persistence *persistenceOption) startPersistence(data interface{}) error {
+func (c *mapCache[K, E]) startPersistence() error {
+	if c.codec == nil || len(c.codec.Name()) == 0 || len(c.codec.Name()) > 255 {
+		return errors.New("the name of the codec must be 1 to 255 bytes")
+	}
+	switch c.persistencePolicy {
+	case FFB:
+		err := c.read()
+		if err != nil {
+			return err
+		}
+		c.lastSave = time.Now()
+		c.stopPersistence = make(chan struct{})
+		c.persistenceDone = make(chan struct{})
+		go c.backup()
+	case AOF:
+		return c.startAof()
+	case Overflow:
+		return c.startOverflow()
+	}
+	return nil
+}
+
+// snapshot file
+func (persistence *persistenceOption) snapshotFile() string {
+	return filepath.Join(persistence.persistencePath, fmt.Sprintf("%s%s", persistence.persistenceName, FileSUFFIX))
+}
+
+// files of the persistence policy, they may not exist
+func (persistence *persistenceOption) persistenceFiles() []string {
 	switch persistence.persistencePolicy {
 	case FFB:
-		err := persistence.read(data)
+		file := persistence.snapshotFile()
+		return []string{file, file + PrevFileSUFFIX, file + tmpFileSUFFIX}
+	case AOF:
+		file := persistence.aofFile()
+		return []string{file, file + tmpFileSUFFIX}
+	case Overflow:
+		return []string{persistence.overflowDir()}
+	}
+	return nil
+}
+
+// judge whether any file of the persistence exists
+func (persistence *persistenceOption) persisted() bool {
+	for _, file := range persistence.persistenceFiles() {
+		if _, err := os.Stat(file); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// remove all files of the persistence
+func (persistence *persistenceOption) removePersistence() error {
+	for _, file := range persistence.persistenceFiles() {
+		err := os.RemoveAll(file)
 		if err != nil {
 			return err
 		}
-		go persistence.backup(data)
 	}
 	return nil
 }
 
-// load file
-func (persistence *persistenceOption) read(data interface{}) error {
-	file := filepath.Join(persistence.persistencePath, fmt.Sprintf("%s%s", persistence.persistenceName, FileSUFFIX))
-	_, err := os.Stat(file)
-	// Skip this step if the file exists
-	if err != nil {
+// load the snapshot
+// If the snapshot is corrupt, the previous generation is loaded instead
+func (c *mapCache[K, E]) read() error {
+	file := c.snapshotFile()
+	items, err := readSnapshot[K, E](file, c.codec)
+	if err == nil {
+		c.items = items
 		return nil
 	}
-	fileData, err := os.OpenFile(file, os.O_RDWR, os.ModePerm)
+	prev := file + PrevFileSUFFIX
+	items, prevErr := readSnapshot[K, E](prev, c.codec)
+	switch {
+	case prevErr == nil:
+		if os.IsNotExist(err) {
+			log.Printf("cache: snapshot %s is missing, recovered from %s", file, prev)
+		} else {
+			log.Printf("cache: snapshot %s is corrupt (%v), recovered from %s", file, err, prev)
+		}
+		c.items = items
+		// write a good snapshot at the next chance
+		c.dirty = 1
+		return nil
+	case os.IsNotExist(err) && os.IsNotExist(prevErr):
+		// first start
+		return nil
+	case os.IsNotExist(prevErr):
+		return fmt.Errorf("%w: %s: %v", ErrCorruptSnapshot, file, err)
+	case os.IsNotExist(err):
+		return fmt.Errorf("%w: %s: %v", ErrCorruptSnapshot, prev, prevErr)
+	default:
+		return fmt.Errorf("%w: %s: %v, %s: %v", ErrCorruptSnapshot, file, err, prev, prevErr)
+	}
+}
+
+// read and verify a snapshot file
+// The snapshot is decoded with the codec recorded in it, files written before the header was introduced are decoded as plain gob
+func readSnapshot[K comparable, E any](file string, configured Codec) (map[K]*Item[E], error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	items := make(map[K]*Item[E])
+	if !bytes.HasPrefix(data, snapshotMagic) {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&items)
+		if err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	data = data[len(snapshotMagic):]
+	if len(data) < 1 {
+		return nil, errors.New("truncated header")
+	}
+	version := data[0]
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unknown version %d", version)
+	}
+	data = data[1:]
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, errors.New("truncated header")
 	}
-	decoder := gob.NewDecoder(fileData)
-	err = decoder.Decode(data)
+	codec, err := findCodec(string(data[1:1+data[0]]), configured)
 	if err != nil {
+		return nil, err
+	}
+	data = data[1+data[0]:]
+	if len(data) < 12 {
+		return nil, errors.New("truncated header")
+	}
+	payload := data[12:]
+	if binary.BigEndian.Uint64(data[0:8]) != uint64(len(payload)) {
+		return nil, errors.New("truncated payload")
+	}
+	if binary.BigEndian.Uint32(data[8:12]) != crc32.ChecksumIEEE(payload) {
+		return nil, errors.New("checksum mismatch")
+	}
+	var entries []snapshotEntry[K, E]
+	err = codec.Unmarshal(payload, &entries)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Item != nil {
+			entry.Item.tags = entry.Tags
+			items[entry.Key] = entry.Item
+		}
+	}
+	return items, nil
+}
+
+// write the data to the persistence file
+func (c *mapCache[K, E]) flush() error {
+	if !c.enablePersistence {
+		return nil
+	}
+	switch c.persistencePolicy {
+	case FFB:
+		return c.snapshot()
+	case AOF:
+		return c.aof.sync()
+	case Overflow:
+		return c.checkpoint()
+	}
+	return nil
+}
+
+// stop persistence and write the data to the persistence file for the last time
+func (c *mapCache[K, E]) stopPersistenceLoop() error {
+	if !c.enablePersistence {
+		return nil
+	}
+	switch c.persistencePolicy {
+	case FFB:
+		close(c.stopPersistence)
+		<-c.persistenceDone
+		c.mu.RLock()
+		dirty := c.dirty
+		c.mu.RUnlock()
+		if dirty == 0 {
+			return nil
+		}
+		return c.snapshot()
+	case AOF:
+		return c.aof.close()
+	case Overflow:
+		close(c.stopPersistence)
+		<-c.persistenceDone
+		err := c.checkpoint()
+		c.mu.Lock()
+		c.disk.close()
+		c.mu.Unlock()
 		return err
 	}
 	return nil
 }
 
-// If an error occurs, it fails the backup
-// If the main process ends, it fails the backup and the file are 0 bytes
-func (persistence *persistenceOption) backup(data interface{}) {
-	file := filepath.Join(persistence.persistencePath, fmt.Sprintf("%s%s", persistence.persistenceName, FileSUFFIX))
-	ticker := time.NewTicker(time.Second * 5)
+// back up the data when the snapshot interval or one of the snapshot rules is satisfied
+// If an error occurs, it fails the backup and the last snapshot is kept
+func (c *mapCache[K, E]) backup() {
+	defer close(c.persistenceDone)
+	tick := c.snapshotTick()
+	if tick <= 0 {
+		<-c.stopPersistence
+		return
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			err := judgeAndCreate(file)
-			if err != nil {
+			if !c.shouldSnapshot(time.Now()) {
 				continue
 			}
-			func() {
-				f, err := os.OpenFile(file, os.O_RDWR, os.ModePerm)
-				if err != nil {
-					return
-				}
-				defer f.Close()
-				encoder := gob.NewEncoder(f)
-				err = encoder.Encode(data)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-			}()
+			err := c.flush()
+			if err != nil {
+				log.Printf("cache: persistence %s failed: %v", c.persistenceName, err)
+			}
+		case <-c.stopPersistence:
+			return
+		}
+	}
+}
+
+// how often the snapshot interval and rules are checked
+// The rules are checked at least every second
+func (c *mapCache[K, E]) snapshotTick() time.Duration {
+	tick := c.snapshotInterval
+	if len(c.snapshotRules) > 0 && (tick <= 0 || tick > time.Second) {
+		tick = time.Second
+	}
+	for _, rule := range c.snapshotRules {
+		if rule.within > 0 && rule.within < tick {
+			tick = rule.within
+		}
+	}
+	return tick
+}
+
+// judge whether a snapshot should be written
+func (c *mapCache[K, E]) shouldSnapshot(now time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.dirty == 0 {
+		return false
+	}
+	elapsed := now.Sub(c.lastSave)
+	if c.snapshotInterval > 0 && elapsed >= c.snapshotInterval {
+		return true
+	}
+	for _, rule := range c.snapshotRules {
+		if c.dirty >= rule.changes && elapsed >= rule.within {
+			return true
+		}
+	}
+	return false
+}
+
+// write a snapshot of the current data
+func (c *mapCache[K, E]) snapshot() error {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	c.mu.RLock()
+	entries := make([]snapshotEntry[K, E], 0, len(c.items))
+	for k, v := range c.items {
+		// the item is copied, the expiration time is extended in place by reading with sliding expiration
+		item := Item[E]{Object: v.Object, Expiration: v.Expiration}
+		entries = append(entries, snapshotEntry[K, E]{Key: k, Item: &item, Tags: v.tags})
+	}
+	dirty := c.dirty
+	c.mu.RUnlock()
+	err := writeSnapshot(c.snapshotFile(), entries, c.codec)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	// changes made while writing are kept for the next snapshot
+	c.dirty -= dirty
+	c.lastSave = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// write a snapshot file atomically
+// The data is written to a temporary file and fsynced, then the current snapshot becomes the previous
+// generation and the temporary file is renamed into place
+func writeSnapshot[K comparable, E any](file string, entries []snapshotEntry[K, E], codec Codec) error {
+	payload, err := codec.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	name := codec.Name()
+	data := make([]byte, 0, len(snapshotMagic)+2+len(name)+12+len(payload))
+	data = append(data, snapshotMagic...)
+	data = append(data, snapshotVersion, byte(len(name)))
+	data = append(data, name...)
+	data = append(data, make([]byte, 12)...)
+	binary.BigEndian.PutUint64(data[len(data)-12:], uint64(len(payload)))
+	binary.BigEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(payload))
+	data = append(data, payload...)
+
+	dir := filepath.Dir(file)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmp := file + tmpFileSUFFIX
+	err = writeFileSync(tmp, data)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_, err = os.Stat(file)
+	if err == nil {
+		err = os.Rename(file, file+PrevFileSUFFIX)
+		if err != nil {
+			return err
 		}
 	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
 
-//Judge whether a file or folder exists. If it does not exist, create it
-func judgeAndCreate(path string) error {
-	_, err := os.Stat(path)
+// write data to a file and fsync it
+func writeFileSync(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
 	if err == nil {
-		return nil
+		err = f.Sync()
 	}
-	err = os.MkdirAll(filepath.Dir(path), 0755)
+	closeErr := f.Close()
 	if err != nil {
 		return err
 	}
-	_, err = os.Create(path)
+	return closeErr
+}
+
+// fsync a directory so that a rename in it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer d.Close()
+	return d.Sync()
 }
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats statistics of a cache, it is enabled by SetEnableStats
+type Stats struct {
+	Hits          int64         // reads finding the data
+	Misses        int64         // reads not finding the data
+	Sets          int64         // data written
+	Deletes       int64         // data deleted or cleared
+	Expirations   int64         // expired data deleted
+	Evictions     int64         // data evicted because the cache is full
+	LoadSuccesses int64         // loads succeeded
+	LoadFailures  int64         // loads failed
+	LoadTime      time.Duration // total time spent loading
+}
+
+// HitRatio the ratio of reads finding the data, 0 when there are no reads
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// AverageLoadTime the average time of a load, 0 when there are no loads
+func (s Stats) AverageLoadTime() time.Duration {
+	total := s.LoadSuccesses + s.LoadFailures
+	if total == 0 {
+		return 0
+	}
+	return s.LoadTime / time.Duration(total)
+}
+
+// add the statistics of another cache
+func (s Stats) add(o Stats) Stats {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Sets += o.Sets
+	s.Deletes += o.Deletes
+	s.Expirations += o.Expirations
+	s.Evictions += o.Evictions
+	s.LoadSuccesses += o.LoadSuccesses
+	s.LoadFailures += o.LoadFailures
+	s.LoadTime += o.LoadTime
+	return s
+}
+
+// statsCollector the counters are updated atomically without the lock
+// All methods do nothing on a nil collector, so a cache without statistics pays only a nil check
+type statsCollector struct {
+	hits          int64
+	misses        int64
+	sets          int64
+	deletes       int64
+	expirations   int64
+	evictions     int64
+	loadSuccesses int64
+	loadFailures  int64
+	loadTime      int64
+}
+
+// count a read
+func (s *statsCollector) read(hit bool) {
+	if s == nil {
+		return
+	}
+	if hit {
+		atomic.AddInt64(&s.hits, 1)
+	} else {
+		atomic.AddInt64(&s.misses, 1)
+	}
+}
+
+// count a write
+func (s *statsCollector) set() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.sets, 1)
+}
+
+// count data removed
+func (s *statsCollector) remove(reason EvictReason, n int64) {
+	if s == nil {
+		return
+	}
+	switch reason {
+	case ReasonExpired:
+		atomic.AddInt64(&s.expirations, n)
+	case ReasonEvicted:
+		atomic.AddInt64(&s.evictions, n)
+	case ReasonDeleted, ReasonCleared:
+		atomic.AddInt64(&s.deletes, n)
+	}
+}
+
+// count a load
+func (s *statsCollector) load(err error, elapsed time.Duration) {
+	if s == nil {
+		return
+	}
+	if err == nil {
+		atomic.AddInt64(&s.loadSuccesses, 1)
+	} else {
+		atomic.AddInt64(&s.loadFailures, 1)
+	}
+	atomic.AddInt64(&s.loadTime, int64(elapsed))
+}
+
+func (s *statsCollector) snapshot() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		Hits:          atomic.LoadInt64(&s.hits),
+		Misses:        atomic.LoadInt64(&s.misses),
+		Sets:          atomic.LoadInt64(&s.sets),
+		Deletes:       atomic.LoadInt64(&s.deletes),
+		Expirations:   atomic.LoadInt64(&s.expirations),
+		Evictions:     atomic.LoadInt64(&s.evictions),
+		LoadSuccesses: atomic.LoadInt64(&s.loadSuccesses),
+		LoadFailures:  atomic.LoadInt64(&s.loadFailures),
+		LoadTime:      time.Duration(atomic.LoadInt64(&s.loadTime)),
+	}
+}
+
+// Stats get the statistics, all zero when they are not enabled
+func (c *mapCache[K, E]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// StatsReporter a cache reporting its statistics
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// StatsHandler export the statistics of the caches in Prometheus text format, the caches are labeled by name
+func StatsHandler(caches map[string]StatsReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(caches))
+		for name := range caches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		stats := make([]Stats, len(names))
+		for i, name := range names {
+			stats[i] = caches[name].Stats()
+		}
+
+		var b strings.Builder
+		metric := func(name, kind, help string, value func(s Stats) string) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+			for i, s := range stats {
+				fmt.Fprintf(&b, "%s{cache=\"%s\"} %s\n", name, escapeLabel(names[i]), value(s))
+			}
+		}
+		counter := func(name, help string, value func(s Stats) int64) {
+			metric(name, "counter", help, func(s Stats) string {
+				return fmt.Sprint(value(s))
+			})
+		}
+		counter("cache_hits_total", "Reads finding the data.", func(s Stats) int64 { return s.Hits })
+		counter("cache_misses_total", "Reads not finding the data.", func(s Stats) int64 { return s.Misses })
+		counter("cache_sets_total", "Data written.", func(s Stats) int64 { return s.Sets })
+		counter("cache_deletes_total", "Data deleted or cleared.", func(s Stats) int64 { return s.Deletes })
+		counter("cache_expirations_total", "Expired data deleted.", func(s Stats) int64 { return s.Expirations })
+		counter("cache_evictions_total", "Data evicted because the cache is full.", func(s Stats) int64 { return s.Evictions })
+		counter("cache_load_successes_total", "Loads succeeded.", func(s Stats) int64 { return s.LoadSuccesses })
+		counter("cache_load_failures_total", "Loads failed.", func(s Stats) int64 { return s.LoadFailures })
+		metric("cache_load_seconds_total", "counter", "Total time spent loading in seconds.", func(s Stats) string {
+			return fmt.Sprint(s.LoadTime.Seconds())
+		})
+		metric("cache_hit_ratio", "gauge", "Ratio of reads finding the data.", func(s Stats) string {
+			return fmt.Sprint(s.HitRatio())
+		})
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
+
+// escape a label value of the Prometheus text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"errors"
+	"net"
+)
+
+// MulticastTransport a Transport over UDP multicast
+// All the processes joining the same group receive the messages, including the sender itself
+type MulticastTransport struct {
+	recv *net.UDPConn
+	send *net.UDPConn
+}
+
+// NewMulticastTransport join the multicast group such as "239.255.0.1:9999" on the interface
+// nil interface means the one chosen by the system
+func NewMulticastTransport(group string, ifi *net.Interface) (*MulticastTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IP.IsMulticast() {
+		return nil, errors.New("not a multicast address")
+	}
+	recv, err := net.ListenMulticastUDP("udp", ifi, addr)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		_ = recv.Close()
+		return nil, err
+	}
+	return &MulticastTransport{recv: recv, send: send}, nil
+}
+
+// Send  send the message to the group
+func (t *MulticastTransport) Send(msg []byte) error {
+	if len(msg) > maxDatagram {
+		return errors.New("message is too large")
+	}
+	_, err := t.send.Write(msg)
+	return err
+}
+
+// Receive  wait for the next message from the group
+func (t *MulticastTransport) Receive() ([]byte, error) {
+	buf := make([]byte, maxDatagram)
+	n, _, err := t.recv.ReadFromUDP(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Close  leave the group
+func (t *MulticastTransport) Close() error {
+	err := t.recv.Close()
+	if e := t.send.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// UnixTransportSUFFIX suffix of the sockets of UnixTransport
+const UnixTransportSUFFIX = ".sock"
+
+// maxDatagram the largest message of the transports
+const maxDatagram = 64 << 10
+
+// UnixTransport a Transport over unix datagram sockets, for the processes on one host
+// Every process binds its own socket in the shared directory and sends to all the other sockets there
+type UnixTransport struct {
+	dir  string
+	path string
+	conn *net.UnixConn
+}
+
+// NewUnixTransport create a UnixTransport in the directory, it is created if it does not exist
+// The sockets of the processes that exit without closing are removed by the next send
+func NewUnixTransport(dir string) (*UnixTransport, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s%s", os.Getpid(), hex.EncodeToString(id), UnixTransportSUFFIX))
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &UnixTransport{dir: dir, path: path, conn: conn}, nil
+}
+
+// Send  send the message to the sockets of all the other processes in the directory
+func (t *UnixTransport) Send(msg []byte) error {
+	if len(msg) > maxDatagram {
+		return errors.New("message is too large")
+	}
+	peers, err := filepath.Glob(filepath.Join(t.dir, "*"+UnixTransportSUFFIX))
+	if err != nil {
+		return err
+	}
+	var res error
+	for _, peer := range peers {
+		if peer == t.path {
+			continue
+		}
+		_, err = t.conn.WriteToUnix(msg, &net.UnixAddr{Name: peer, Net: "unixgram"})
+		switch {
+		case err == nil:
+		case errors.Is(err, syscall.ECONNREFUSED):
+			// nobody listens on it any more
+			_ = os.Remove(peer)
+		case errors.Is(err, os.ErrNotExist):
+			// the peer is closed in the meantime
+		default:
+			if res == nil {
+				res = fmt.Errorf("send to %s failed: %w", peer, err)
+			}
+		}
+	}
+	return res
+}
+
+// Receive  wait for the next message from the peers
+func (t *UnixTransport) Receive() ([]byte, error) {
+	buf := make([]byte, maxDatagram)
+	n, _, err := t.conn.ReadFromUnix(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Close  close the socket and remove it from the directory
+func (t *UnixTransport) Close() error {
+	err := t.conn.Close()
+	if e := os.Remove(t.path); e != nil && !os.IsNotExist(e) && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestUpdate(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int]()
+	a.Equal(nil, err)
+	incr := func(old int, exists bool) (int, bool) {
+		return old + 1, true
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Update("counter", incr)
+		}()
+	}
+	wg.Wait()
+	value, _ := c.Get("counter")
+	a.Equal(100, value)
+
+	// not stored
+	value, ok := c.Update("missing", func(old int, exists bool) (int, bool) {
+		a.Equal(false, exists)
+		return 1, false
+	})
+	a.Equal(0, value)
+	a.Equal(false, ok)
+	_, ok = c.Get("missing")
+	a.Equal(false, ok)
+
+	// the expiration time is kept
+	c.SetDefault("ttl", 1, time.Hour)
+	_, before, _ := c.GetWithExpiration("ttl")
+	c.Update("ttl", incr)
+	value, after, _ := c.GetWithExpiration("ttl")
+	a.Equal(2, value)
+	a.Equal(before, after)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[[]int]()
+	a.Equal(nil, err)
+	a.Equal(false, c.CompareAndSwap("1", nil, []int{1}))
+	c.Set("1", []int{1})
+	a.Equal(false, c.CompareAndSwap("1", []int{2}, []int{3}))
+	a.Equal(true, c.CompareAndSwap("1", []int{1}, []int{2}))
+	value, _ := c.Get("1")
+	a.Equal([]int{2}, value)
+
+	a.Equal(false, c.CompareAndDelete("1", []int{1}))
+	a.Equal(true, c.CompareAndDelete("1", []int{2}))
+	_, ok := c.Get("1")
+	a.Equal(false, ok)
+}
+
+type anyValue struct {
+	V any
+}
+
+func TestCompareAndSwapInterfaceField(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[anyValue]()
+	a.Equal(nil, err)
+	c.Set("1", anyValue{V: []int{1}})
+	a.Equal(false, c.CompareAndSwap("1", anyValue{V: []int{2}}, anyValue{V: 3}))
+	a.Equal(true, c.CompareAndSwap("1", anyValue{V: []int{1}}, anyValue{V: 2}))
+	a.Equal(true, c.CompareAndDelete("1", anyValue{V: 2}))
+}
+
+func TestGetOrSetAndReplace(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[string]()
+	a.Equal(nil, err)
+	a.Equal(false, c.Replace("1", "a"))
+	_, ok := c.Get("1")
+	a.Equal(false, ok)
+
+	value, loaded := c.GetOrSet("1", "a")
+	a.Equal("a", value)
+	a.Equal(false, loaded)
+	value, loaded = c.GetOrSet("1", "b")
+	a.Equal("a", value)
+	a.Equal(true, loaded)
+
+	a.Equal(true, c.Replace("1", "c"))
+	value, _ = c.Get("1")
+	a.Equal("c", value)
+
+	c.SetDefault("2", "expired", -time.Second)
+	a.Equal(false, c.Replace("2", "d"))
+	value, loaded = c.GetOrSet("2", "e")
+	a.Equal("e", value)
+	a.Equal(false, loaded)
+}
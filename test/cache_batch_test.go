@@ -0,0 +1,57 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestBatch(t *testing.T) {
+	for _, create := range []func(opts ...cache.CreateOptionFunc) (cache.MapInterface[int], error){
+		cache.NewMapCache[int],
+		cache.NewShardedMapCache[int],
+	} {
+		a := assert.NewAssert(t)
+		evicted := make(map[string]cache.EvictReason)
+		c, err := create(cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+			evicted[key] = reason
+		}))
+		a.Equal(nil, err)
+		items := make(map[string]int)
+		for i := 0; i < 10; i++ {
+			items[fmt.Sprint(i)] = i
+		}
+		c.SetMany(items, 0)
+		a.Equal(10, len(c.Keys()))
+		c.SetMany(map[string]int{"0": 10, "x": 11}, time.Millisecond)
+		a.Equal(cache.ReasonReplaced, evicted["0"])
+
+		time.Sleep(2 * time.Millisecond)
+		a.Equal(map[string]int{"1": 1, "2": 2}, c.GetMany([]string{"0", "1", "2", "x", "y"}))
+
+		a.Equal(2, c.DeleteMany([]string{"1", "2", "0", "y"}))
+		a.Equal(cache.ReasonDeleted, evicted["1"])
+		a.Equal(cache.ReasonDeleted, evicted["2"])
+		a.Equal(0, len(c.GetMany([]string{"1", "2"})))
+	}
+}
+
+func TestSetManyEviction(t *testing.T) {
+	a := assert.NewAssert(t)
+	evicted := 0
+	c, err := cache.NewMapCache[int](cache.SetMaxEntries(5), cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+		a.Equal(cache.ReasonEvicted, reason)
+		evicted++
+	}))
+	a.Equal(nil, err)
+	items := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		items[fmt.Sprint(i)] = i
+	}
+	c.SetMany(items, cache.DefaultExpiration)
+	a.Equal(5, len(c.Keys()))
+	a.Equal(5, evicted)
+}
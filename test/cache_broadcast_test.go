@@ -0,0 +1,217 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+// eventually wait until cond holds or the timeout passes
+func eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// hubTransport an in-memory bus delivering every message to all members in order, including the sender
+// The messages received are held back while hold is locked
+type hubTransport struct {
+	hub  *hub
+	ch   chan []byte
+	hold sync.Mutex
+}
+
+type hub struct {
+	mu      sync.Mutex
+	members []*hubTransport
+}
+
+func (h *hub) join() *hubTransport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := &hubTransport{hub: h, ch: make(chan []byte, 64)}
+	h.members = append(h.members, t)
+	return t
+}
+
+func (t *hubTransport) Send(msg []byte) error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+	for _, m := range t.hub.members {
+		m.ch <- msg
+	}
+	return nil
+}
+
+func (t *hubTransport) Receive() ([]byte, error) {
+	msg, ok := <-t.ch
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	t.hold.Lock()
+	defer t.hold.Unlock()
+	return msg, nil
+}
+
+func (t *hubTransport) Close() error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+	for i, m := range t.hub.members {
+		if m == t {
+			t.hub.members = append(t.hub.members[:i], t.hub.members[i+1:]...)
+		}
+	}
+	close(t.ch)
+	return nil
+}
+
+func TestBroadcasterEcho(t *testing.T) {
+	a := assert.NewAssert(t)
+	h := &hub{}
+	c1, _ := cache.NewMapCache[int]()
+	c2, _ := cache.NewMapCache[int]()
+	t1 := h.join()
+	b1, err := cache.NewBroadcaster[int](c1, t1)
+	a.Equal(nil, err)
+	b2, err := cache.NewBroadcaster[int](c2, h.join())
+	a.Equal(nil, err)
+
+	c1.Set("1", 1)
+	c2.Set("1", 1)
+	t1.hold.Lock()
+	a.Equal(nil, b1.Delete("1"))
+	a.Equal(true, eventually(time.Second, func() bool {
+		_, ok := c2.Get("1")
+		return !ok
+	}))
+
+	// the data set again before our own message comes back is kept
+	c1.Set("1", 1)
+	t1.hold.Unlock()
+	c1.Set("barrier", 0)
+	a.Equal(nil, b2.Delete("barrier"))
+	a.Equal(true, eventually(time.Second, func() bool {
+		_, ok := c1.Get("barrier")
+		return !ok
+	}))
+	_, ok := c1.Get("1")
+	a.Equal(true, ok)
+
+	c1.SetWithTags("2", 2, 0, "even")
+	c2.SetWithTags("2", 2, 0, "even")
+	c2.Set("3", 3)
+	a.Equal(nil, b2.InvalidateTag("even"))
+	_, ok = c2.Get("2")
+	a.Equal(false, ok)
+	a.Equal(true, eventually(time.Second, func() bool {
+		_, ok := c1.Get("2")
+		return !ok
+	}))
+	a.Equal(1, c2.Len())
+
+	a.Equal(nil, b1.Close())
+	a.Equal(true, b1.Close() != nil)
+	a.Equal(true, b1.Delete("1") != nil)
+	a.Equal(nil, b2.Close())
+}
+
+func TestUnixTransport(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir, err := os.MkdirTemp("", "bus")
+	a.Equal(nil, err)
+	defer os.RemoveAll(dir)
+
+	caches := make([]cache.MapInterface[int], 3)
+	broadcasters := make([]*cache.Broadcaster[int], 3)
+	for i := range caches {
+		caches[i], _ = cache.NewMapCache[int]()
+		transport, err := cache.NewUnixTransport(dir)
+		a.Equal(nil, err)
+		broadcasters[i], err = cache.NewBroadcaster[int](caches[i], transport)
+		a.Equal(nil, err)
+		for j := 0; j < 10; j++ {
+			caches[i].SetWithTags(fmt.Sprint(j), j, 0, fmt.Sprint("mod", j%3))
+		}
+	}
+
+	a.Equal(nil, broadcasters[0].Delete("0", "1"))
+	a.Equal(nil, broadcasters[1].InvalidateTag("mod2"))
+	for _, c := range caches {
+		c := c
+		a.Equal(true, eventually(time.Second, func() bool {
+			return c.Len() == 5
+		}))
+		a.Equal([]string{"3", "4", "6", "7", "9"}, sortedKeys[int](c))
+	}
+
+	// the socket of a closed peer is gone, and the sockets left by the dead ones are removed
+	a.Equal(nil, broadcasters[2].Close())
+	stale, err := cache.NewUnixTransport(dir)
+	a.Equal(nil, err)
+	a.Equal(nil, stale.Close())
+	_, err = os.Create(fmt.Sprint(dir, "/dead", cache.UnixTransportSUFFIX))
+	a.Equal(nil, err)
+	a.Equal(nil, broadcasters[0].Delete("3"))
+	a.Equal(true, eventually(time.Second, func() bool {
+		_, ok := caches[1].Get("3")
+		return !ok
+	}))
+	_, ok := caches[2].Get("3")
+	a.Equal(true, ok)
+	for _, b := range broadcasters[:2] {
+		a.Equal(nil, b.Close())
+	}
+}
+
+func TestMulticastTransport(t *testing.T) {
+	a := assert.NewAssert(t)
+	group := fmt.Sprintf("239.255.0.1:%d", 20000+os.Getpid()%10000)
+	t1, err := cache.NewMulticastTransport(group, nil)
+	if err != nil {
+		t.Skip("multicast is not available:", err)
+	}
+	t2, err := cache.NewMulticastTransport(group, nil)
+	if err != nil {
+		_ = t1.Close()
+		t.Skip("multicast is not available:", err)
+	}
+	c1, _ := cache.NewMapCache[int]()
+	c2, _ := cache.NewMapCache[int]()
+	b1, err := cache.NewBroadcaster[int](c1, t1)
+	a.Equal(nil, err)
+	b2, err := cache.NewBroadcaster[int](c2, t2)
+	a.Equal(nil, err)
+	defer b1.Close()
+	defer b2.Close()
+
+	c1.Set("1", 1)
+	c2.Set("1", 1)
+	if err = b1.Delete("1"); err != nil {
+		t.Skip("multicast is not available:", err)
+	}
+	if !eventually(time.Second, func() bool {
+		_, ok := c2.Get("1")
+		return !ok
+	}) {
+		t.Skip("multicast is not routed on this host")
+	}
+	c1.Set("1", 1)
+	c2.SetWithTags("2", 2, 0, "two")
+	a.Equal(nil, b1.InvalidateTag("two"))
+	a.Equal(true, eventually(time.Second, func() bool {
+		return c2.Len() == 0
+	}))
+	_, ok := c1.Get("1")
+	a.Equal(true, ok)
+}
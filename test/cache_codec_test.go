@@ -0,0 +1,116 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+type codecValue struct {
+	Name    string
+	Age     int
+	Score   float64
+	Tags    []string
+	Attrs   map[string]int
+	Parent  *codecValue
+	Created time.Time
+	Raw     []byte
+}
+
+func newCodecValue() codecValue {
+	return codecValue{
+		Name:    "lomtom",
+		Age:     -18,
+		Score:   99.5,
+		Tags:    []string{"a", "b"},
+		Attrs:   map[string]int{"x": 1},
+		Parent:  &codecValue{Name: "parent"},
+		Created: time.Date(2022, 2, 24, 16, 44, 30, 0, time.UTC),
+		Raw:     []byte{0, 1, 2},
+	}
+}
+
+func TestCodec(t *testing.T) {
+	a := assert.NewAssert(t)
+	for _, codec := range []cache.Codec{cache.GobCodec, cache.JSONCodec, cache.BinaryCodec} {
+		data, err := codec.Marshal(newCodecValue())
+		a.Equal(nil, err)
+		var v codecValue
+		a.Equal(nil, codec.Unmarshal(data, &v))
+		a.Equal(newCodecValue(), v)
+	}
+
+	_, err := cache.BinaryCodec.Marshal(struct{ V any }{V: 1})
+	a.Equal(true, err != nil)
+	var v codecValue
+	a.Equal(true, cache.BinaryCodec.Unmarshal([]byte{1}, &v) != nil)
+}
+
+func TestAofCodec(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := func(codec cache.Codec) []cache.CreateOptionFunc {
+		return []cache.CreateOptionFunc{
+			cache.SetEnablePersistence("codec"),
+			cache.SetPersistencePolicy(cache.AOF),
+			cache.SetPersistencePath(dir),
+			cache.SetAofFsync(cache.FsyncAlways),
+			cache.SetCodec(codec),
+		}
+	}
+	c, err := cache.NewMapCache[codecValue](opts(cache.JSONCodec)...)
+	a.Equal(nil, err)
+	c.Set("1", newCodecValue())
+
+	// the file is converted to the configured codec
+	for _, codec := range []cache.Codec{cache.BinaryCodec, cache.GobCodec, cache.JSONCodec} {
+		c, err = cache.NewMapCache[codecValue](opts(codec)...)
+		a.Equal(nil, err)
+		v, ok := c.Get("1")
+		a.Equal(true, ok)
+		a.Equal(newCodecValue(), v)
+	}
+}
+
+// upperCodec a user codec
+type upperCodec struct{}
+
+func (upperCodec) Name() string {
+	return "upper"
+}
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return []byte(strings.ToUpper(string(data))), err
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func TestUserCodec(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("codec"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+		cache.SetCodec(upperCodec{}),
+	}
+	c, err := cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	c.Set("1", "a")
+
+	c, err = cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	v, _ := c.Get("1")
+	a.Equal("A", v)
+
+	// the codec recorded in the file is unknown
+	_, err = cache.NewMapCache[string](opts[:3]...)
+	a.Equal(true, err != nil)
+}
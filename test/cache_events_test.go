@@ -0,0 +1,196 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+// receive the events buffered in the channel, and whether the channel is closed
+func drain[E any](events <-chan cache.Event[string, E]) ([]cache.Event[string, E], bool) {
+	var res []cache.Event[string, E]
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return res, true
+			}
+			res = append(res, e)
+		default:
+			return res, false
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int]()
+	a.Equal(nil, err)
+	all, cancelAll := c.Subscribe(nil)
+	deletes, cancelDeletes := c.Subscribe(func(e cache.Event[string, int]) bool {
+		return e.Op == cache.EventDelete
+	})
+	defer cancelDeletes()
+
+	start := time.Now()
+	c.Set("1", 1)
+	c.Set("1", 2)
+	c.Delete("1")
+	c.Delete("1")
+	c.Set("2", 3)
+	c.Clear()
+	c.Get("2")
+
+	events, closed := drain(all)
+	a.Equal(false, closed)
+	a.Equal(5, len(events))
+	type change struct {
+		op       cache.EventOp
+		key      string
+		old, new int
+	}
+	var changes []change
+	for _, e := range events {
+		a.Equal(true, !e.Time.Before(start))
+		changes = append(changes, change{e.Op, e.Key, e.Old, e.New})
+	}
+	a.Equal([]change{
+		{cache.EventSet, "1", 0, 1},
+		{cache.EventSet, "1", 1, 2},
+		{cache.EventDelete, "1", 2, 0},
+		{cache.EventSet, "2", 0, 3},
+		{cache.EventClear, "2", 3, 0},
+	}, changes)
+	events, _ = drain(deletes)
+	a.Equal(1, len(events))
+
+	cancelAll()
+	cancelAll()
+	c.Set("3", 3)
+	events, closed = drain(all)
+	a.Equal(0, len(events))
+	a.Equal(true, closed)
+
+	a.Equal(nil, c.Close())
+	_, closed = drain(deletes)
+	a.Equal(true, closed)
+	all, _ = c.Subscribe(nil)
+	_, closed = drain(all)
+	a.Equal(true, closed)
+}
+
+func TestSubscribeExpireAndEvict(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int](cache.SetMaxEntries(1))
+	a.Equal(nil, err)
+	events, cancel := c.Subscribe(func(e cache.Event[string, int]) bool {
+		return e.Op != cache.EventSet
+	})
+	defer cancel()
+	c.SetDefault("1", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	c.Set("2", 2)
+	c.Set("3", 3)
+	res, _ := drain(events)
+	a.Equal(2, len(res))
+	a.Equal(cache.EventExpire, res[0].Op)
+	a.Equal("1", res[0].Key)
+	a.Equal(cache.EventEvict, res[1].Op)
+	a.Equal("2", res[1].Key)
+	a.Equal("evict", res[1].Op.String())
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	a := assert.NewAssert(t)
+
+	// the events beyond the buffer are dropped
+	c, err := cache.NewMapCache[int](cache.SetEventBuffer(2))
+	a.Equal(nil, err)
+	events, cancel := c.Subscribe(nil)
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	res, closed := drain(events)
+	a.Equal(2, len(res))
+	a.Equal(false, closed)
+	cancel()
+
+	// the subscriber is disconnected
+	c, err = cache.NewMapCache[int](cache.SetEventBuffer(2), cache.SetEventOverflow(cache.OverflowDisconnect))
+	a.Equal(nil, err)
+	events, cancel = c.Subscribe(nil)
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	res, closed = drain(events)
+	a.Equal(2, len(res))
+	a.Equal(true, closed)
+	cancel()
+
+	// the changes wait for the subscriber, no event is lost
+	c, err = cache.NewMapCache[int](cache.SetEventBuffer(1), cache.SetEventOverflow(cache.OverflowBlock))
+	a.Equal(nil, err)
+	events, cancel = c.Subscribe(nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprint(i), i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		e := <-events
+		a.Equal(i, e.New)
+	}
+	<-done
+
+	// the subscriber reads the cache while the changes wait for it
+	go c.SetMany(map[string]int{"x": 1, "y": 2, "z": 3}, 0)
+	time.Sleep(10 * time.Millisecond)
+	go c.Set("w", 4)
+	time.Sleep(10 * time.Millisecond)
+	read := make(chan bool)
+	go func() {
+		_, ok := c.Get("x")
+		for i := 0; i < 4; i++ {
+			<-events
+		}
+		read <- ok
+	}()
+	select {
+	case ok := <-read:
+		a.Equal(true, ok)
+	case <-time.After(time.Second):
+		t.Fatal("the changes are blocked by the subscriber reading the cache")
+	}
+
+	// canceling wakes up the blocked change
+	go func() {
+		c.Set("a", 1)
+		c.Set("b", 2)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	c.Set("c", 3)
+	a.Equal(nil, c.Close())
+}
+
+func TestShardedSubscribe(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewShardedMapCache[int](cache.SetShards(4))
+	a.Equal(nil, err)
+	events, cancel := c.Subscribe(nil)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	res, _ := drain(events)
+	a.Equal(20, len(res))
+	a.Equal(nil, c.Close())
+	_, closed := drain(events)
+	a.Equal(true, closed)
+}
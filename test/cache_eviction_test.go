@@ -0,0 +1,224 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func sortedKeys[E any](c cache.MapInterface[E]) []string {
+	keys := c.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+func TestMaxEntries(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int](cache.SetMaxEntries(2))
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.Set("2", 2)
+	c.Get("1")
+	c.Set("3", 3)
+	a.Equal([]string{"1", "3"}, sortedKeys(c))
+
+	// overwriting does not evict
+	c.Set("3", 4)
+	a.Equal([]string{"1", "3"}, sortedKeys(c))
+
+	c.Delete("1")
+	c.Set("4", 4)
+	a.Equal([]string{"3", "4"}, sortedKeys(c))
+
+	c.Clear()
+	c.Set("5", 5)
+	c.Set("6", 6)
+	a.Equal([]string{"5", "6"}, sortedKeys(c))
+}
+
+func TestMaxEntriesPersistence(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("lru"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+	}
+	c, err := cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	for _, k := range []string{"1", "2", "3"} {
+		c.Set(k, 1)
+	}
+	a.Equal(nil, c.Close())
+
+	c, err = cache.NewMapCache[int](append(opts, cache.SetMaxEntries(2))...)
+	a.Equal(nil, err)
+	a.Equal(2, len(c.Keys()))
+	a.Equal(nil, c.Close())
+
+	// the evicted data is not loaded again
+	c, err = cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	a.Equal(2, len(c.Keys()))
+	a.Equal(nil, c.Close())
+}
+
+func TestLfu(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int](cache.SetMaxEntries(2), cache.SetEvictionPolicy(cache.LFU))
+	a.Equal(nil, err)
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("a")
+	c.Get("a")
+	c.Set("b", 2)
+	c.Set("c", 3)
+	a.Equal([]string{"a", "c"}, sortedKeys(c))
+
+	// aging, the data used frequently long ago is evicted eventually
+	c.Set("d", 4)
+	_, ok := c.Get("a")
+	a.Equal(true, ok)
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint("x", i), i)
+		_, ok = c.Get(fmt.Sprint("x", i))
+		a.Equal(true, ok)
+	}
+	_, ok = c.Get("a")
+	a.Equal(false, ok)
+}
+
+func TestArc(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int](cache.SetMaxEntries(3), cache.SetEvictionPolicy(cache.ARC))
+	a.Equal(nil, err)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("b")
+
+	// a scan does not evict the data used more than once
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint("x", i), i)
+	}
+	a.Equal([]string{"a", "b", "x9"}, sortedKeys(c))
+
+	// ghost hits adapt to the workload
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("x", i%5), i)
+		c.Get(fmt.Sprint("x", (i+1)%5))
+		a.Equal(3, len(c.Keys()))
+	}
+	c.Delete("a")
+	c.Clear()
+	c.Set("a", 1)
+	a.Equal([]string{"a"}, sortedKeys(c))
+}
+
+func TestMaxCost(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[string](cache.SetMaxCost(10), cache.SetCostFunc(func(value string) int64 {
+		return int64(len(value))
+	}))
+	a.Equal(nil, err)
+	c.Set("1", "aaaa")
+	c.Set("2", "bbbb")
+	a.Equal(int64(8), c.Cost())
+	c.Get("1")
+	c.Set("3", "cccc")
+	a.Equal([]string{"1", "3"}, sortedKeys(c))
+	a.Equal(int64(8), c.Cost())
+
+	// overwriting changes the cost
+	c.Set("3", "cccccc")
+	a.Equal([]string{"1", "3"}, sortedKeys(c))
+	a.Equal(int64(10), c.Cost())
+
+	// explicit cost
+	c.SetWithCost("4", "d", 6)
+	a.Equal([]string{"4"}, sortedKeys(c))
+	a.Equal(int64(6), c.Cost())
+
+	// data costing more than the maximum is not kept, and the other data is not evicted for it
+	c.SetWithCost("5", "e", 11)
+	a.Equal([]string{"4"}, sortedKeys(c))
+	a.Equal(int64(6), c.Cost())
+	c.SetWithCost("4", "dd", 11)
+	a.Equal(0, len(c.Keys()))
+	a.Equal(int64(0), c.Cost())
+
+	c.Set("6", "ff")
+	c.Delete("6")
+	a.Equal(int64(0), c.Cost())
+	c.Set("7", "gg")
+	c.Clear()
+	a.Equal(int64(0), c.Cost())
+}
+
+func TestMaxCostOversized(t *testing.T) {
+	a := assert.NewAssert(t)
+	for _, policy := range []cache.EvictionPolicy{cache.LRU, cache.LFU, cache.ARC} {
+		c, err := cache.NewMapCache[int](cache.SetMaxCost(100), cache.SetEvictionPolicy(policy))
+		a.Equal(nil, err)
+		for i := 0; i < 5; i++ {
+			c.SetWithCost(fmt.Sprint(i), i, 10)
+		}
+		c.SetWithCost("big", 0, 200)
+		a.Equal(5, c.Len())
+		a.Equal(int64(50), c.Cost())
+		_, ok := c.Get("big")
+		a.Equal(false, ok)
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	a := assert.NewAssert(t)
+	_, err := cache.NewMapCache[int](cache.SetCostFunc(func(value string) int64 {
+		return 1
+	}))
+	a.Equal(true, err != nil)
+
+	c, err := cache.NewMapCache[int](cache.SetMaxCost(2))
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.Set("2", 2)
+	c.Set("3", 3)
+	a.Equal([]string{"2", "3"}, sortedKeys(c))
+	a.Equal(int64(2), c.Cost())
+}
+
+func TestOnEvicted(t *testing.T) {
+	a := assert.NewAssert(t)
+	var c cache.MapInterface[int]
+	evicted := make(map[string]cache.EvictReason)
+	c, err := cache.NewMapCache[int](cache.SetMaxEntries(2), cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+		evicted[fmt.Sprint(key, "=", value)] = reason
+		// the lock is released
+		c.Keys()
+	}))
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.Set("1", 2)
+	c.Set("2", 2)
+	c.Set("3", 3)
+	c.Delete("2")
+	c.SetDefault("4", 4, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	c.Clear()
+	a.Equal(map[string]cache.EvictReason{
+		"1=1": cache.ReasonReplaced,
+		"1=2": cache.ReasonEvicted,
+		"2=2": cache.ReasonDeleted,
+		"4=4": cache.ReasonExpired,
+		"3=3": cache.ReasonCleared,
+	}, evicted)
+	a.Equal("expired", cache.ReasonExpired.String())
+
+	_, err = cache.NewMapCache[string](cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {}))
+	a.Equal(true, err != nil)
+}
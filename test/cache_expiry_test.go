@@ -0,0 +1,109 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestDeleteExpiredBatches(t *testing.T) {
+	a := assert.NewAssert(t)
+	expired := 0
+	c, err := cache.NewMapCache[int](cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+		if reason == cache.ReasonExpired {
+			expired++
+		}
+	}))
+	a.Equal(nil, err)
+	// overwriting changes the expiration time
+	c.SetDefault("o0", 0, time.Millisecond)
+	c.SetDefault("o0", 0, time.Hour)
+	c.SetDefault("o1", 1, time.Millisecond)
+	c.Set("o1", 1)
+	// more than one batch
+	for i := 0; i < 5000; i++ {
+		c.SetDefault(fmt.Sprint("e", i), i, time.Millisecond)
+		c.Set(fmt.Sprint("k", i), i)
+	}
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	a.Equal(5000, expired)
+	a.Equal(5002, len(c.Keys()))
+	_, ok := c.Get("o0")
+	a.Equal(true, ok)
+	_, ok = c.Get("o1")
+	a.Equal(true, ok)
+
+	c.SetDefault("e2", 2, time.Millisecond)
+	c.Delete("e2")
+	c.SetDefault("e3", 3, time.Millisecond)
+	c.Clear()
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	a.Equal(5000, expired)
+}
+
+// a few data items expire in a large cache
+func BenchmarkDeleteExpired(b *testing.B) {
+	c, _ := cache.NewMapCache[int]()
+	for i := 0; i < 1000000; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.SetDefault("expired", i, -time.Second)
+		c.DeleteExpired()
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int](cache.SetSlidingExpiration(50 * time.Millisecond))
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.SetDefault("2", 2, 50*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_, ok := c.Get("1")
+		a.Equal(true, ok)
+	}
+	// the fixed expiration time is not extended
+	_, ok := c.Get("2")
+	a.Equal(false, ok)
+
+	_, deadline, ok := c.GetWithExpiration("1")
+	a.Equal(true, ok)
+	a.Equal(true, time.Until(deadline) > 40*time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	_, ok = c.Get("1")
+	a.Equal(false, ok)
+	a.Equal(nil, c.Close())
+}
+
+func TestSetSliding(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int]()
+	a.Equal(nil, err)
+	c.SetSliding("1", 1, 50*time.Millisecond)
+	_, first, _ := c.GetWithExpiration("1")
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_, ok := c.Get("1")
+		a.Equal(true, ok)
+	}
+	_, deadline, ok := c.GetWithExpiration("1")
+	a.Equal(true, ok)
+	a.Equal(true, deadline.After(first))
+
+	// overwriting with a fixed expiration time
+	c.Set("1", 1)
+	_, ok = c.Get("1")
+	a.Equal(true, ok)
+	c.SetSliding("2", 2, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c.DeleteExpired()
+	a.Equal([]string{"1"}, c.Keys())
+}
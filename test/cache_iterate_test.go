@@ -0,0 +1,111 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestIterate(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int]()
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.SetDefault("2", 2, time.Hour)
+	c.SetDefault("3", 3, -time.Second)
+	a.Equal(2, c.Len())
+	a.Equal([]string{"1", "2"}, sortedKeys(c))
+
+	items := c.Items()
+	a.Equal(2, len(items))
+	a.Equal(1, items["1"].Object)
+	a.Equal(int64(0), items["1"].Expiration)
+	_, expiration, _ := c.GetWithExpiration("2")
+	a.Equal(expiration.UnixMicro(), items["2"].Expiration)
+
+	// the copy is not changed by the cache
+	c.Set("1", 10)
+	a.Equal(1, items["1"].Object)
+
+	sum := 0
+	c.Range(func(key string, value int) bool {
+		sum += value
+		// the cache can be used in fn
+		c.Set("4", 4)
+		return true
+	})
+	a.Equal(12, sum)
+	count := 0
+	c.Range(func(key string, value int) bool {
+		count++
+		return false
+	})
+	a.Equal(1, count)
+}
+
+// all operations run concurrently with gc, run with -race
+func TestConcurrentAccess(t *testing.T) {
+	for _, policy := range []cache.Persistence{cache.FFB, cache.AOF, cache.Overflow} {
+		concurrentAccess(t, policy)
+	}
+}
+
+func concurrentAccess(t *testing.T, policy cache.Persistence) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	c, err := cache.NewMapCache[int](
+		cache.SetPersistencePolicy(policy),
+		cache.SetExpirationTime(time.Millisecond),
+		cache.SetGcInterval(time.Millisecond),
+		cache.SetMaxEntries(50),
+		cache.SetEnablePersistence("race"),
+		cache.SetPersistencePath(dir),
+		cache.SetSnapshotInterval(time.Millisecond),
+	)
+	a.Equal(nil, err)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprint(i % 20)
+				c.Set(key, i)
+				c.SetDefault(key, i, time.Millisecond)
+				c.SetSliding(key, i, time.Millisecond)
+				_ = c.Add(fmt.Sprint(g, "-", i), i)
+				c.Get(key)
+				c.GetWithExpiration(key)
+				c.GetAndExpired(key)
+				c.GetAndDelete(key)
+				_, _ = c.IsExpired(key)
+				c.Update(key, func(old int, exists bool) (int, bool) {
+					return old + 1, true
+				})
+				c.CompareAndSwap(key, i, i+1)
+				c.GetOrSet(key, i)
+				c.SetMany(map[string]int{key: i}, 0)
+				c.GetMany([]string{key})
+				c.Keys()
+				c.Len()
+				c.Items()
+				c.Range(func(key string, value int) bool {
+					return true
+				})
+				if i%50 == 0 {
+					c.Clear()
+					_ = c.Flush()
+				}
+				c.DeleteMany([]string{key})
+				c.Delete(key)
+				c.DeleteExpired()
+			}
+		}(g)
+	}
+	wg.Wait()
+	a.Equal(nil, c.Close())
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+type userKey struct {
+	Tenant string
+	ID     int
+}
+
+func TestKeyMapCache(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewKeyMapCache[int, string](cache.SetMaxEntries(2))
+	a.Equal(nil, err)
+	c.Set(1, "1")
+	c.Set(2, "2")
+	c.Get(1)
+	c.Set(3, "3")
+	keys := c.Keys()
+	sort.Ints(keys)
+	a.Equal([]int{1, 3}, keys)
+	a.Equal(true, c.Add(1, "1") != nil)
+
+	c.SetDefault(4, "4", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	_, ok := c.Get(4)
+	a.Equal(false, ok)
+	c.DeleteExpired()
+	a.Equal(1, len(c.Keys()))
+	a.Equal(nil, c.Close())
+
+	var evicted []userKey
+	s, err := cache.NewKeyMapCache[userKey, int](cache.OnEvicted(func(key userKey, value int, reason cache.EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	a.Equal(nil, err)
+	s.Set(userKey{"a", 1}, 1)
+	value, ok := s.Get(userKey{"a", 1})
+	a.Equal(true, ok)
+	a.Equal(1, value)
+	s.Delete(userKey{"a", 1})
+	a.Equal([]userKey{{"a", 1}}, evicted)
+}
+
+func TestKeyMapCachePersistence(t *testing.T) {
+	for _, policy := range []cache.Persistence{cache.FFB, cache.AOF} {
+		for _, codec := range []cache.Codec{cache.GobCodec, cache.JSONCodec, cache.BinaryCodec} {
+			a := assert.NewAssert(t)
+			opts := []cache.CreateOptionFunc{
+				cache.SetEnablePersistence("key"),
+				cache.SetPersistencePolicy(policy),
+				cache.SetPersistencePath(t.TempDir()),
+				cache.SetCodec(codec),
+			}
+			c, err := cache.NewKeyMapCache[userKey, string](opts...)
+			a.Equal(nil, err)
+			c.Set(userKey{"a", 1}, "a1")
+			c.Set(userKey{"b", 2}, "b2")
+			c.Delete(userKey{"b", 2})
+			a.Equal(nil, c.Close())
+
+			c, err = cache.NewKeyMapCache[userKey, string](opts...)
+			a.Equal(nil, err)
+			a.Equal([]userKey{{"a", 1}}, c.Keys())
+			value, ok := c.Get(userKey{"a", 1})
+			a.Equal(true, ok)
+			a.Equal("a1", value)
+			a.Equal(nil, c.Close())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	a := assert.NewAssert(t)
+	var loads int32
+	release := make(chan struct{})
+	c, err := cache.NewMapCache[string](cache.SetLoader(func(key string) (string, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "v" + key, nil
+	}))
+	a.Equal(nil, err)
+
+	// concurrent misses are coalesced into a single load
+	var wg sync.WaitGroup
+	values := make([]string, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = c.GetOrLoad("1", nil)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	a.Equal(int32(1), atomic.LoadInt32(&loads))
+	for _, value := range values {
+		a.Equal("v1", value)
+	}
+	value, ok := c.Get("1")
+	a.Equal(true, ok)
+	a.Equal("v1", value)
+
+	// a given loader is preferred
+	value, err = c.GetOrLoad("2", func(key string) (string, error) {
+		return "w" + key, nil
+	})
+	a.Equal(nil, err)
+	a.Equal("w2", value)
+
+	// the panic is propagated, and the key can be loaded again
+	func() {
+		defer func() {
+			a.Equal("boom", recover())
+		}()
+		_, _ = c.GetOrLoad("3", func(key string) (string, error) {
+			panic("boom")
+		})
+	}()
+	value, err = c.GetOrLoad("3", func(key string) (string, error) {
+		return "w" + key, nil
+	})
+	a.Equal(nil, err)
+	a.Equal("w3", value)
+
+	d, _ := cache.NewMapCache[string]()
+	_, err = d.GetOrLoad("1", nil)
+	a.Equal(cache.ErrNoLoader, err)
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	a := assert.NewAssert(t)
+	errLoad := errors.New("load failed")
+	loads := 0
+	loader := func(key string) (int, error) {
+		loads++
+		return 0, errLoad
+	}
+
+	c, _ := cache.NewMapCache[int]()
+	_, err := c.GetOrLoad("1", loader)
+	a.Equal(errLoad, err)
+	_, err = c.GetOrLoad("1", loader)
+	a.Equal(errLoad, err)
+	a.Equal(2, loads)
+	a.Equal(0, len(c.Keys()))
+
+	// the error is cached
+	loads = 0
+	c, _ = cache.NewMapCache[int](cache.SetNegativeTTL(20 * time.Millisecond))
+	_, err = c.GetOrLoad("1", loader)
+	a.Equal(errLoad, err)
+	_, err = c.GetOrLoad("1", loader)
+	a.Equal(errLoad, err)
+	a.Equal(1, loads)
+	time.Sleep(30 * time.Millisecond)
+	_, err = c.GetOrLoad("1", loader)
+	a.Equal(errLoad, err)
+	a.Equal(2, loads)
+
+	// deleting the key forgets the error
+	c.Delete("1")
+	value, err := c.GetOrLoad("1", func(key string) (int, error) {
+		return 1, nil
+	})
+	a.Equal(nil, err)
+	a.Equal(1, value)
+}
+
+func TestRefreshAfter(t *testing.T) {
+	a := assert.NewAssert(t)
+	var version int32
+	c, err := cache.NewMapCache[int32](cache.SetRefreshAfter(10*time.Millisecond),
+		cache.SetLoader(func(key string) (int32, error) {
+			return atomic.AddInt32(&version, 1), nil
+		}))
+	a.Equal(nil, err)
+	value, err := c.GetOrLoad("1", nil)
+	a.Equal(nil, err)
+	a.Equal(int32(1), value)
+
+	// the stale data is served while reloading
+	time.Sleep(20 * time.Millisecond)
+	value, _ = c.Get("1")
+	a.Equal(int32(1), value)
+	time.Sleep(50 * time.Millisecond)
+	value, _ = c.Get("1")
+	a.Equal(int32(2), value)
+}
+
+func TestStaleGrace(t *testing.T) {
+	a := assert.NewAssert(t)
+	var fail int32
+	c, err := cache.NewMapCache[string](cache.SetExpirationTime(10*time.Millisecond), cache.SetStaleGrace(time.Second),
+		cache.SetLoader(func(key string) (string, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return "", errors.New("load failed")
+			}
+			return "v" + key, nil
+		}))
+	a.Equal(nil, err)
+	c.Set("1", "old")
+
+	// the expired data is served if the reload fails
+	atomic.StoreInt32(&fail, 1)
+	time.Sleep(20 * time.Millisecond)
+	c.DeleteExpired()
+	value, ok := c.Get("1")
+	a.Equal(true, ok)
+	a.Equal("old", value)
+	time.Sleep(50 * time.Millisecond)
+	value, err = c.GetOrLoad("1", nil)
+	a.Equal(nil, err)
+	a.Equal("old", value)
+
+	atomic.StoreInt32(&fail, 0)
+	time.Sleep(50 * time.Millisecond)
+	c.Get("1")
+	time.Sleep(50 * time.Millisecond)
+	value, _ = c.Get("1")
+	a.Equal("v1", value)
+	a.Equal(nil, c.Close())
+}
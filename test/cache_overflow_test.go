@@ -0,0 +1,252 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func overflowOptions(dir string, opts ...cache.CreateOptionFunc) []cache.CreateOptionFunc {
+	return append([]cache.CreateOptionFunc{
+		cache.SetEnablePersistence("overflow"),
+		cache.SetPersistencePath(dir),
+		cache.SetPersistencePolicy(cache.Overflow),
+		cache.SetMaxEntries(10),
+	}, opts...)
+}
+
+func TestOverflow(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	_, err := cache.NewMapCache[int](overflowOptions(dir, cache.SetMaxEntries(0))...)
+	a.Equal(true, err != nil)
+
+	var evicted int
+	c, err := cache.NewMapCache[int](overflowOptions(dir, cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+		evicted++
+	}))...)
+	a.Equal(nil, err)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	c.SetWithTags("tagged", 100, 0, "a")
+	// the data beyond the limit is spilled instead of evicted
+	a.Equal(0, evicted)
+	a.Equal(101, c.Len())
+	a.Equal(int64(10), c.Cost())
+	for i := 0; i < 100; i++ {
+		v, ok := c.Get(fmt.Sprint(i))
+		a.Equal(i, v)
+		a.Equal(true, ok)
+	}
+	a.Equal(int64(10), c.Cost())
+
+	v, ok := c.Delete("0")
+	a.Equal(0, v)
+	a.Equal(true, ok)
+	c.Set("1", -1)
+	a.Equal(100, len(c.Items()))
+	a.Equal(nil, c.Close())
+
+	c, err = cache.NewMapCache[int](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	a.Equal(100, c.Len())
+	_, ok = c.Get("0")
+	a.Equal(false, ok)
+	v, _ = c.Get("1")
+	a.Equal(-1, v)
+	v, _ = c.Get("99")
+	a.Equal(99, v)
+	a.Equal(1, c.InvalidateTag("a"))
+
+	c.Clear()
+	a.Equal(0, c.Len())
+	a.Equal(nil, c.Close())
+	c, err = cache.NewMapCache[int](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	a.Equal(0, c.Len())
+	a.Equal(nil, c.Close())
+}
+
+func TestOverflowCompaction(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := overflowOptions(dir, cache.SetOverflowSegmentSize(1024), cache.SetMaxEntries(2))
+	c, err := cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 20; i++ {
+			c.Set(fmt.Sprint(i), fmt.Sprint(round, "-", i))
+		}
+	}
+	a.Equal(nil, c.Flush())
+	// the segments mostly garbage are removed
+	segments, err := filepath.Glob(filepath.Join(dir, "overflow"+cache.OverflowDirSUFFIX, "*.seg"))
+	a.Equal(nil, err)
+	a.Equal(true, len(segments) < 10)
+	a.Equal(nil, c.Close())
+
+	c, err = cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	for i := 0; i < 20; i++ {
+		v, _ := c.Get(fmt.Sprint(i))
+		a.Equal(fmt.Sprint(49, "-", i), v)
+	}
+	a.Equal(nil, c.Close())
+}
+
+// the garbage of the deletes is compacted by the checkpoint, even without spilling afterwards
+func TestOverflowCompactDeleted(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	c, err := cache.NewMapCache[string](overflowOptions(dir, cache.SetOverflowSegmentSize(1024))...)
+	a.Equal(nil, err)
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		c.Set(keys[i], strings.Repeat("v", 20))
+	}
+	a.Equal(nil, c.Flush())
+	size := func() int64 {
+		segments, err := filepath.Glob(filepath.Join(dir, "overflow"+cache.OverflowDirSUFFIX, "*.seg"))
+		a.Equal(nil, err)
+		var total int64
+		for _, segment := range segments {
+			info, err := os.Stat(segment)
+			a.Equal(nil, err)
+			total += info.Size()
+		}
+		return total
+	}
+	a.Equal(true, size() > 0)
+
+	a.Equal(200, c.DeleteMany(keys))
+	a.Equal(nil, c.Flush())
+	a.Equal(int64(0), size())
+	a.Equal(nil, c.Close())
+	c, err = cache.NewMapCache[string](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	a.Equal(0, c.Len())
+	a.Equal(nil, c.Close())
+}
+
+// the spilled data costing more than the maximum is dropped when it is read back
+func TestOverflowOversized(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	cost := cache.SetCostFunc(func(value string) int64 {
+		return int64(len(value))
+	})
+	c, err := cache.NewMapCache[string](overflowOptions(dir, cost, cache.SetMaxCost(1000))...)
+	a.Equal(nil, err)
+	c.Set("big", strings.Repeat("b", 100))
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprint(i), "s")
+	}
+	a.Equal(nil, c.Close())
+
+	c, err = cache.NewMapCache[string](overflowOptions(dir, cost, cache.SetMaxCost(50))...)
+	a.Equal(nil, err)
+	for i := 0; i < 10; i++ {
+		c.Get(fmt.Sprint(i))
+	}
+	_, ok := c.Get("big")
+	a.Equal(false, ok)
+	a.Equal(20, c.Len())
+	// the data read back is kept
+	a.Equal(int64(10), c.Cost())
+	a.Equal(nil, c.Close())
+}
+
+// the spilled data is dropped when the index is corrupt
+func TestOverflowCorruptIndex(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	c, err := cache.NewMapCache[int](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	a.Equal(nil, c.Close())
+	index := filepath.Join(dir, "overflow"+cache.OverflowDirSUFFIX, "index")
+	a.Equal(nil, os.WriteFile(index, []byte("GUCI\x01"), 0644))
+
+	c, err = cache.NewMapCache[int](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	a.Equal(0, c.Len())
+	c.Set("1", 1)
+	v, _ := c.Get("1")
+	a.Equal(1, v)
+	a.Equal(nil, c.Close())
+}
+
+// the records that do not match the index are treated as corruption, they are skipped and deleted
+func TestOverflowStaleIndex(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	c, err := cache.NewMapCache[int](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	for i := 10; i < 30; i++ {
+		c.Set(fmt.Sprint(i), 1)
+	}
+	a.Equal(nil, c.Close())
+
+	// the records are of the same size, reversing them keeps every record valid but at the place of another key
+	segments, err := filepath.Glob(filepath.Join(dir, "overflow"+cache.OverflowDirSUFFIX, "*.seg"))
+	a.Equal(nil, err)
+	for _, segment := range segments {
+		data, err := os.ReadFile(segment)
+		a.Equal(nil, err)
+		if len(data) == 0 {
+			continue
+		}
+		a.Equal(0, len(data)%20)
+		size := len(data) / 20
+		reversed := make([]byte, 0, len(data))
+		for i := 19; i >= 0; i-- {
+			reversed = append(reversed, data[i*size:(i+1)*size]...)
+		}
+		a.Equal(nil, os.WriteFile(segment, reversed, 0644))
+	}
+
+	c, err = cache.NewMapCache[int](overflowOptions(dir)...)
+	a.Equal(nil, err)
+	a.Equal(0, len(c.Items()))
+	_, ok := c.Delete("10")
+	a.Equal(false, ok)
+	_, ok = c.GetAndDelete("11")
+	a.Equal(false, ok)
+	_, ok = c.Get("12")
+	a.Equal(false, ok)
+	a.Equal(17, c.Len())
+	a.Equal(nil, c.Close())
+}
+
+func TestShardedOverflow(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := overflowOptions(dir, cache.SetShards(4), cache.SetMaxEntries(8))
+	c, err := cache.NewShardedMapCache[int](opts...)
+	a.Equal(nil, err)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	a.Equal(true, c.Cost() <= 8)
+	a.Equal(nil, c.Close())
+
+	// the data is moved to its new shard
+	c, err = cache.NewShardedMapCache[int](append(opts, cache.SetShards(8))...)
+	a.Equal(nil, err)
+	sum := 0
+	c.Range(func(key string, value int) bool {
+		sum += value
+		return true
+	})
+	a.Equal(4950, sum)
+	a.Equal(nil, c.Close())
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestShardedMapCache(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewShardedMapCache[int](cache.SetShards(4))
+	a.Equal(nil, err)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	a.Equal(100, len(c.Keys()))
+	a.Equal(int64(100), c.Cost())
+	value, ok := c.Get("42")
+	a.Equal(true, ok)
+	a.Equal(42, value)
+	a.Equal(true, c.Add("42", 0) != nil)
+
+	value, ok = c.GetAndDelete("42")
+	a.Equal(true, ok)
+	a.Equal(42, value)
+	_, ok = c.Get("42")
+	a.Equal(false, ok)
+
+	c.SetDefault("1", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	a.Equal(98, len(c.Keys()))
+
+	c.Clear()
+	a.Equal(0, len(c.Keys()))
+	a.Equal(nil, c.Close())
+	a.Equal(cache.ErrClosed, c.Add("1", 1))
+
+	_, err = cache.NewShardedMapCache[int](cache.SetShards(0))
+	a.Equal(true, err != nil)
+}
+
+func TestShardedMapCacheMaxEntries(t *testing.T) {
+	a := assert.NewAssert(t)
+	evicted := 0
+	c, err := cache.NewShardedMapCache[int](cache.SetShards(4), cache.SetMaxEntries(8),
+		cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+			evicted++
+		}))
+	a.Equal(nil, err)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	a.Equal(true, len(c.Keys()) <= 8)
+	a.Equal(100-len(c.Keys()), evicted)
+}
+
+func TestShardedMapCachePersistence(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("sharded"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+	}
+	c, err := cache.NewShardedMapCache[int](append(opts, cache.SetShards(4))...)
+	a.Equal(nil, err)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), i)
+	}
+	a.Equal(nil, c.Close())
+
+	// the data is moved to its shard when the number of shards grows
+	c, err = cache.NewShardedMapCache[int](append(opts, cache.SetShards(8), cache.SetEnableStats(true))...)
+	a.Equal(nil, err)
+	a.Equal(100, len(c.Keys()))
+	// moving the data is not counted
+	stats := c.Stats()
+	a.Equal(int64(0), stats.Sets)
+	a.Equal(int64(0), stats.Deletes)
+	for i := 0; i < 100; i++ {
+		value, ok := c.Get(fmt.Sprint(i))
+		a.Equal(true, ok)
+		a.Equal(i, value)
+	}
+	a.Equal(nil, c.Close())
+
+	c, err = cache.NewShardedMapCache[int](append(opts, cache.SetShards(8))...)
+	a.Equal(nil, err)
+	a.Equal(100, len(c.Keys()))
+	a.Equal(nil, c.Close())
+}
+
+// the files of removed shards and of an unsharded cache are loaded and removed
+func TestShardedMapCacheAdopt(t *testing.T) {
+	a := assert.NewAssert(t)
+	for _, policy := range []cache.Persistence{cache.FFB, cache.AOF} {
+		dir := t.TempDir()
+		opts := []cache.CreateOptionFunc{
+			cache.SetEnablePersistence("sharded"),
+			cache.SetPersistencePolicy(policy),
+			cache.SetPersistencePath(dir),
+		}
+		c, err := cache.NewMapCache[int](opts...)
+		a.Equal(nil, err)
+		c.Set("unsharded", -1)
+		a.Equal(nil, c.Close())
+		c, err = cache.NewShardedMapCache[int](append(opts, cache.SetShards(8))...)
+		a.Equal(nil, err)
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprint(i), i)
+		}
+		a.Equal(nil, c.Close())
+
+		c, err = cache.NewShardedMapCache[int](append(opts, cache.SetShards(2), cache.SetEnableStats(true))...)
+		a.Equal(nil, err)
+		a.Equal(101, c.Len())
+		a.Equal(int64(0), c.Stats().Sets)
+		a.Equal(int64(0), c.Stats().Deletes)
+		v, _ := c.Get("unsharded")
+		a.Equal(-1, v)
+		v, _ = c.Get("99")
+		a.Equal(99, v)
+		a.Equal(nil, c.Close())
+		files, err := filepath.Glob(filepath.Join(dir, "*.cdb"))
+		a.Equal(nil, err)
+		a.Equal(2, len(files))
+
+		c, err = cache.NewShardedMapCache[int](append(opts, cache.SetShards(2))...)
+		a.Equal(nil, err)
+		a.Equal(101, c.Len())
+		a.Equal(nil, c.Close())
+	}
+}
+
+// read heavy parallel load, one write in ten operations
+func benchmarkParallel(b *testing.B, c cache.MapInterface[int]) {
+	keys := make([]string, 1<<12)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		c.Set(keys[i], i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(keys))
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				c.Set(key, i)
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMapCacheParallel(b *testing.B) {
+	c, _ := cache.NewMapCache[int]()
+	benchmarkParallel(b, c)
+}
+
+func BenchmarkShardedMapCacheParallel(b *testing.B) {
+	c, _ := cache.NewShardedMapCache[int]()
+	benchmarkParallel(b, c)
+}
+
+func BenchmarkMapCacheParallelLRU(b *testing.B) {
+	c, _ := cache.NewMapCache[int](cache.SetMaxEntries(1 << 16))
+	benchmarkParallel(b, c)
+}
+
+func BenchmarkShardedMapCacheParallelLRU(b *testing.B) {
+	c, _ := cache.NewShardedMapCache[int](cache.SetMaxEntries(1 << 16))
+	benchmarkParallel(b, c)
+}
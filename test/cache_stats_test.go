@@ -0,0 +1,79 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestStats(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int](cache.SetEnableStats(true), cache.SetMaxEntries(2))
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.Set("2", 2)
+	c.Set("3", 3)
+	c.Get("3")
+	c.Get("1")
+	c.GetMany([]string{"2", "3"})
+	c.Delete("2")
+	c.SetDefault("4", 4, -time.Second)
+	c.DeleteExpired()
+	c.Clear()
+	_, _ = c.GetOrLoad("5", func(key string) (int, error) {
+		time.Sleep(time.Millisecond)
+		return 5, nil
+	})
+	_, _ = c.GetOrLoad("6", func(key string) (int, error) {
+		return 0, errors.New("load failed")
+	})
+
+	stats := c.Stats()
+	a.Equal(int64(3), stats.Hits)
+	a.Equal(int64(3), stats.Misses)
+	a.Equal(int64(5), stats.Sets)
+	a.Equal(int64(2), stats.Deletes)
+	a.Equal(int64(1), stats.Expirations)
+	a.Equal(int64(1), stats.Evictions)
+	a.Equal(int64(1), stats.LoadSuccesses)
+	a.Equal(int64(1), stats.LoadFailures)
+	a.Equal(true, stats.LoadTime >= time.Millisecond)
+	a.Equal(0.5, stats.HitRatio())
+
+	// disabled
+	d, _ := cache.NewMapCache[int]()
+	d.Get("1")
+	a.Equal(cache.Stats{}, d.Stats())
+	a.Equal(0.0, d.Stats().HitRatio())
+}
+
+func TestStatsHandler(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, _ := cache.NewMapCache[int](cache.SetEnableStats(true))
+	s, _ := cache.NewShardedMapCache[string](cache.SetEnableStats(true))
+	c.Set("1", 1)
+	c.Get("1")
+	s.Get("1")
+	handler := cache.StatsHandler(map[string]cache.StatsReporter{"ints": c, `str"ings`: s})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	for _, line := range []string{
+		"# TYPE cache_hits_total counter",
+		`cache_hits_total{cache="ints"} 1`,
+		`cache_misses_total{cache="str\"ings"} 1`,
+		`cache_sets_total{cache="ints"} 1`,
+		"# TYPE cache_hit_ratio gauge",
+		`cache_hit_ratio{cache="ints"} 1`,
+		`cache_hit_ratio{cache="str\"ings"} 0`,
+	} {
+		a.Equal(true, strings.Contains(string(body), line+"\n"))
+	}
+	a.Equal(true, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestTags(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewMapCache[int]()
+	a.Equal(nil, err)
+	c.SetWithTags("user:1", 1, 0, "users", "tenant:a")
+	c.SetWithTags("user:2", 2, time.Hour, "users", "users")
+	c.SetWithTags("order:1", 3, 0, "tenant:a")
+	c.Set("order:2", 4)
+
+	a.Equal(2, c.InvalidateTag("tenant:a"))
+	a.Equal([]string{"order:2", "user:2"}, sortedKeys(c))
+	a.Equal(0, c.InvalidateTag("tenant:a"))
+
+	// overwriting the data replaces its tags
+	c.Set("user:2", 2)
+	a.Equal(0, c.InvalidateTag("users"))
+	a.Equal(2, c.Len())
+
+	c.SetWithTags("user:3", 3, 0)
+	a.Equal(2, c.DeletePrefix("user:"))
+	a.Equal([]string{"order:2"}, sortedKeys(c))
+
+	// atomic updates keep the tags
+	c.SetWithTags("user:4", 4, 0, "users")
+	c.Update("user:4", func(old int, exists bool) (int, bool) {
+		return old + 1, true
+	})
+	a.Equal(1, c.InvalidateTag("users"))
+}
+
+func TestTagsExpirationAndEviction(t *testing.T) {
+	a := assert.NewAssert(t)
+	var reasons []cache.EvictReason
+	c, err := cache.NewMapCache[int](
+		cache.SetMaxEntries(2),
+		cache.OnEvicted(func(key string, value int, reason cache.EvictReason) {
+			reasons = append(reasons, reason)
+		}),
+	)
+	a.Equal(nil, err)
+	c.SetWithTags("1", 1, time.Millisecond, "a")
+	c.SetWithTags("2", 2, 0, "a")
+	time.Sleep(5 * time.Millisecond)
+	c.DeleteExpired()
+	// the expired data left the index, a new data with the same key is not tagged
+	c.Set("1", 10)
+	c.SetWithTags("3", 3, 0, "a")
+	a.Equal([]cache.EvictReason{cache.ReasonExpired, cache.ReasonEvicted}, reasons)
+	a.Equal(1, c.InvalidateTag("a"))
+	a.Equal([]string{"1"}, sortedKeys(c))
+}
+
+func TestTagsPersistence(t *testing.T) {
+	for _, policy := range []cache.Persistence{cache.FFB, cache.AOF} {
+		dir := t.TempDir()
+		opts := []cache.CreateOptionFunc{
+			cache.SetEnablePersistence("tags"),
+			cache.SetPersistencePath(dir),
+			cache.SetPersistencePolicy(policy),
+			cache.SetCodec(cache.BinaryCodec),
+		}
+		a := assert.NewAssert(t)
+		c, err := cache.NewMapCache[int](opts...)
+		a.Equal(nil, err)
+		c.SetWithTags("1", 1, 0, "a", "b")
+		c.SetWithTags("2", 2, 0, "b")
+		c.SetWithTags("3", 3, 0, "a")
+		c.Delete("3")
+		a.Equal(nil, c.Close())
+
+		c, err = cache.NewMapCache[int](opts...)
+		a.Equal(nil, err)
+		a.Equal(2, c.InvalidateTag("b"))
+		a.Equal(0, c.InvalidateTag("a"))
+		a.Equal(0, c.Len())
+		a.Equal(nil, c.Close())
+	}
+}
+
+func TestShardedTags(t *testing.T) {
+	a := assert.NewAssert(t)
+	c, err := cache.NewShardedMapCache[int](cache.SetShards(4))
+	a.Equal(nil, err)
+	for i := 0; i < 20; i++ {
+		c.SetWithTags(string(rune('a'+i)), i, 0, "all")
+	}
+	c.SetWithTags("prefix:1", 1, 0)
+	c.SetWithTags("prefix:2", 2, 0)
+	a.Equal(2, c.DeletePrefix("prefix:"))
+	a.Equal(20, c.InvalidateTag("all"))
+	a.Equal(0, c.Len())
+}
@@ -1,9 +1,14 @@
 package test
 
 import (
+	"github.com/lomtom/go-utils/assert"
 	"github.com/lomtom/go-utils/cache"
 
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -45,3 +50,277 @@ func TestGc(t *testing.T) {
 		index += 1
 	}
 }
+
+func TestAof(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("aof"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+		cache.SetAofFsync(cache.FsyncAlways),
+	}
+	c, err := cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	c.SetDefault("2", 2, time.Hour)
+	a.Equal(nil, c.Add("3", 3))
+	c.Set("4", 4)
+	c.Delete("1")
+	c.GetAndDelete("3")
+	c.GetAndExpired("4")
+
+	c, err = cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	_, ok := c.Get("1")
+	a.Equal(false, ok)
+	v, ok := c.Get("2")
+	a.Equal(true, ok)
+	a.Equal(2, v)
+	_, ok = c.Get("3")
+	a.Equal(false, ok)
+	_, ok = c.Get("4")
+	a.Equal(false, ok)
+
+	c.Clear()
+	c.Set("5", 5)
+	c, err = cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	a.Equal([]string{"5"}, c.Keys())
+}
+
+func TestAofTruncatedTail(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("aof"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+		cache.SetAofFsync(cache.FsyncAlways),
+	}
+	c, err := cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	c.Set("1", "a")
+
+	// simulate a crash in the middle of a write
+	f, err := os.OpenFile(filepath.Join(dir, "aof"+cache.AofFileSUFFIX), os.O_WRONLY|os.O_APPEND, 0644)
+	a.Equal(nil, err)
+	_, err = f.Write([]byte{0, 0, 1})
+	a.Equal(nil, err)
+	a.Equal(nil, f.Close())
+
+	c, err = cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	v, _ := c.Get("1")
+	a.Equal("a", v)
+	c.Set("2", "b")
+	c, err = cache.NewMapCache[string](opts...)
+	a.Equal(nil, err)
+	v, _ = c.Get("2")
+	a.Equal("b", v)
+}
+
+func TestAofRewrite(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "aof"+cache.AofFileSUFFIX)
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("aof"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+		cache.SetAofRewrite(0, 0),
+	}
+	c, err := cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	for i := 0; i < 1000; i++ {
+		c.Set("1", i)
+		c.Set(fmt.Sprint(i), i)
+		c.Delete(fmt.Sprint(i))
+	}
+	before, err := os.Stat(file)
+	a.Equal(nil, err)
+	a.Equal(nil, c.RewriteAof())
+	c.Set("2", 2)
+	after, err := os.Stat(file)
+	a.Equal(nil, err)
+	a.Equal(true, after.Size() < before.Size()/100)
+
+	c, err = cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	a.Equal(2, len(c.Keys()))
+	v, _ := c.Get("1")
+	a.Equal(999, v)
+	v, _ = c.Get("2")
+	a.Equal(2, v)
+
+	c, err = cache.NewMapCache[int]()
+	a.Equal(nil, err)
+	a.Equal(false, c.RewriteAof() == nil)
+}
+
+func TestAofAutoRewrite(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "aof"+cache.AofFileSUFFIX)
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("aof"),
+		cache.SetPersistencePolicy(cache.AOF),
+		cache.SetPersistencePath(dir),
+		cache.SetAofRewrite(4096, 100),
+	}
+	c, err := cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	for i := 0; i < 10000; i++ {
+		c.Set(fmt.Sprint(i%10), i)
+	}
+	time.Sleep(time.Millisecond * 100)
+	info, err := os.Stat(file)
+	a.Equal(nil, err)
+	a.Equal(true, info.Size() < 4096*4)
+
+	c, err = cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	a.Equal(10, len(c.Keys()))
+	v, _ := c.Get("9")
+	a.Equal(9999, v)
+}
+
+func TestSnapshotRecovery(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ffb"+cache.FileSUFFIX)
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("ffb"),
+		cache.SetPersistencePath(dir),
+	}
+
+	// snapshot written before the header was introduced
+	f, err := os.Create(file + cache.PrevFileSUFFIX)
+	a.Equal(nil, err)
+	a.Equal(nil, gob.NewEncoder(f).Encode(map[string]*cache.Item[int]{"1": {Object: 1}}))
+	a.Equal(nil, f.Close())
+
+	// the process crashed in the middle of writing the snapshot
+	a.Equal(nil, os.WriteFile(file, []byte("GUCS\x01\x00\x00"), 0644))
+	c, err := cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	v, ok := c.Get("1")
+	a.Equal(true, ok)
+	a.Equal(1, v)
+
+	a.Equal(nil, os.WriteFile(file+cache.PrevFileSUFFIX, nil, 0644))
+	_, err = cache.NewMapCache[int](opts...)
+	a.Equal(true, errors.Is(err, cache.ErrCorruptSnapshot))
+}
+
+func TestSnapshotGenerations(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ffb"+cache.FileSUFFIX)
+	opts := []cache.CreateOptionFunc{
+		cache.SetEnablePersistence("ffb"),
+		cache.SetPersistencePath(dir),
+	}
+	c, err := cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	c.Set("1", 1)
+	a.Equal(nil, c.Flush())
+	c.Set("2", 2)
+	a.Equal(nil, c.Close())
+
+	// flip a byte of the payload, the previous generation is loaded
+	data, err := os.ReadFile(file)
+	a.Equal(nil, err)
+	data[len(data)-1] ^= 0xff
+	a.Equal(nil, os.WriteFile(file, data, 0644))
+	c, err = cache.NewMapCache[int](opts...)
+	a.Equal(nil, err)
+	a.Equal([]string{"1"}, c.Keys())
+}
+
+func TestClose(t *testing.T) {
+	a := assert.NewAssert(t)
+	for _, policy := range []cache.Persistence{cache.FFB, cache.AOF} {
+		dir := t.TempDir()
+		opts := []cache.CreateOptionFunc{
+			cache.SetEnablePersistence("close"),
+			cache.SetPersistencePolicy(policy),
+			cache.SetPersistencePath(dir),
+			cache.SetExpirationTime(time.Hour),
+			cache.SetGcInterval(time.Millisecond),
+		}
+		c, err := cache.NewMapCache[int](opts...)
+		a.Equal(nil, err)
+		c.Set("1", 1)
+		a.Equal(nil, c.Flush())
+		c.Set("2", 2)
+		a.Equal(nil, c.Close())
+
+		a.Equal(cache.ErrClosed, c.Close())
+		a.Equal(cache.ErrClosed, c.Flush())
+		a.Equal(cache.ErrClosed, c.StartGc())
+		a.Equal(cache.ErrClosed, c.StopGc())
+		a.Equal(cache.ErrClosed, c.Add("3", 3))
+		_, err = c.IsExpired("1")
+		a.Equal(cache.ErrClosed, err)
+		c.Set("3", 3)
+		_, ok := c.Get("1")
+		a.Equal(false, ok)
+		a.Equal(0, len(c.Keys()))
+
+		c, err = cache.NewMapCache[int](opts...)
+		a.Equal(nil, err)
+		a.Equal(2, len(c.Keys()))
+		a.Equal(nil, c.Close())
+	}
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ffb"+cache.FileSUFFIX)
+	c, err := cache.NewMapCache[int](
+		cache.SetEnablePersistence("ffb"),
+		cache.SetPersistencePath(dir),
+		cache.SetSnapshotInterval(time.Millisecond*50),
+	)
+	a.Equal(nil, err)
+	defer c.Close()
+	time.Sleep(time.Millisecond * 200)
+	_, err = os.Stat(file)
+	a.Equal(true, os.IsNotExist(err))
+
+	c.Set("1", 1)
+	time.Sleep(time.Millisecond * 200)
+	_, err = os.Stat(file)
+	a.Equal(nil, err)
+
+	// nothing has changed, the snapshot is not written again
+	time.Sleep(time.Millisecond * 200)
+	_, err = os.Stat(file + cache.PrevFileSUFFIX)
+	a.Equal(true, os.IsNotExist(err))
+}
+
+func TestSnapshotRule(t *testing.T) {
+	a := assert.NewAssert(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ffb"+cache.FileSUFFIX)
+	c, err := cache.NewMapCache[int](
+		cache.SetEnablePersistence("ffb"),
+		cache.SetPersistencePath(dir),
+		cache.SetSnapshotInterval(0),
+		cache.SetSnapshotRule(time.Millisecond*100, 3),
+	)
+	a.Equal(nil, err)
+	defer c.Close()
+	c.Set("1", 1)
+	c.Set("2", 2)
+	time.Sleep(time.Millisecond * 300)
+	_, err = os.Stat(file)
+	a.Equal(true, os.IsNotExist(err))
+
+	c.Set("3", 3)
+	time.Sleep(time.Millisecond * 300)
+	_, err = os.Stat(file)
+	a.Equal(nil, err)
+}
@@ -0,0 +1,328 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lomtom/go-utils/assert"
+	"github.com/lomtom/go-utils/cache"
+)
+
+func TestFileBackend(t *testing.T) {
+	a := assert.NewAssert(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	b, err := cache.NewFileBackend[string](dir, cache.BinaryCodec)
+	a.Equal(nil, err)
+	a.Equal(nil, b.Set(ctx, "1", "a", 0))
+	a.Equal(nil, b.Set(ctx, "2", "b", time.Millisecond))
+	a.Equal(nil, b.Set(ctx, "1", "c", 0))
+
+	// the data survives restarts
+	b, err = cache.NewFileBackend[string](dir, cache.BinaryCodec)
+	a.Equal(nil, err)
+	v, ok, err := b.Get(ctx, "1")
+	a.Equal("c", v)
+	a.Equal(true, ok)
+	a.Equal(nil, err)
+	time.Sleep(5 * time.Millisecond)
+	_, ok, _ = b.Get(ctx, "2")
+	a.Equal(false, ok)
+
+	a.Equal(nil, b.Delete(ctx, "1"))
+	a.Equal(nil, b.Delete(ctx, "1"))
+	_, ok, _ = b.Get(ctx, "1")
+	a.Equal(false, ok)
+
+	a.Equal(nil, b.Set(ctx, "3", "d", time.Millisecond))
+	a.Equal(nil, b.Set(ctx, "4", "e", 0))
+	time.Sleep(5 * time.Millisecond)
+	a.Equal(nil, b.DeleteExpired(ctx))
+	_, ok, _ = b.Get(ctx, "4")
+	a.Equal(true, ok)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = b.Get(canceled, "4")
+	a.Equal(context.Canceled, err)
+}
+
+// countingBackend a backend in memory counting the reads
+type countingBackend struct {
+	mu    sync.Mutex
+	data  map[string]int
+	reads int32
+	fail  error
+}
+
+func newCountingBackend() *countingBackend {
+	return &countingBackend{data: make(map[string]int)}
+}
+
+func (b *countingBackend) Get(ctx context.Context, key string) (int, bool, error) {
+	atomic.AddInt32(&b.reads, 1)
+	time.Sleep(time.Millisecond)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.data[key]
+	return v, ok, nil
+}
+
+func (b *countingBackend) Set(ctx context.Context, key string, value int, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail != nil {
+		return b.fail
+	}
+	b.data[key] = value
+	return nil
+}
+
+func (b *countingBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.data, key)
+	return nil
+}
+
+func (b *countingBackend) get(key string) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.data[key]
+	return v, ok
+}
+
+func TestTieredCache(t *testing.T) {
+	a := assert.NewAssert(t)
+	ctx := context.Background()
+	l2 := newCountingBackend()
+	_, err := cache.NewTieredCache[int](l2, cache.SetL2Expiration(time.Second))
+	a.Equal(true, err != nil)
+
+	c, err := cache.NewTieredCache[int](l2,
+		cache.SetExpirationTime(20*time.Millisecond),
+		cache.SetL2Expiration(time.Second),
+	)
+	a.Equal(nil, err)
+	a.Equal(nil, c.Set(ctx, "1", 1))
+	v, _ := l2.get("1")
+	a.Equal(1, v)
+	v, ok, err := c.Get(ctx, "1")
+	a.Equal(1, v)
+	a.Equal(true, ok)
+	a.Equal(int32(0), atomic.LoadInt32(&l2.reads))
+
+	// L1 expires, the data is read through from L2 once and promoted
+	time.Sleep(30 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, ok, err := c.Get(ctx, "1")
+			a.Equal(1, v)
+			a.Equal(true, ok)
+			a.Equal(nil, err)
+		}()
+	}
+	wg.Wait()
+	a.Equal(int32(1), atomic.LoadInt32(&l2.reads))
+	_, ok = c.L1().Get("1")
+	a.Equal(true, ok)
+
+	_, ok, err = c.Get(ctx, "2")
+	a.Equal(false, ok)
+	a.Equal(nil, err)
+
+	// L1 is not changed if writing L2 fails
+	l2.fail = errors.New("full")
+	a.Equal(l2.fail, c.Set(ctx, "1", 2))
+	v, _, _ = c.Get(ctx, "1")
+	a.Equal(1, v)
+	l2.fail = nil
+
+	a.Equal(nil, c.Delete(ctx, "1"))
+	_, ok = l2.get("1")
+	a.Equal(false, ok)
+	_, ok, _ = c.Get(ctx, "1")
+	a.Equal(false, ok)
+	a.Equal(nil, c.Close())
+	a.Equal(cache.ErrClosed, c.Set(ctx, "1", 1))
+}
+
+func TestTieredCacheWriteBehind(t *testing.T) {
+	a := assert.NewAssert(t)
+	ctx := context.Background()
+	l2, err := cache.NewFileBackend[int](t.TempDir(), nil)
+	a.Equal(nil, err)
+	c, err := cache.NewTieredCache[int](l2, cache.SetWriteMode(cache.WriteBehind), cache.SetWriteQueue(4))
+	a.Equal(nil, err)
+	for i := 0; i < 20; i++ {
+		a.Equal(nil, c.Set(ctx, fmt.Sprint(i), i))
+	}
+	a.Equal(nil, c.Delete(ctx, "0"))
+	a.Equal(nil, c.Flush(ctx))
+	v, ok, _ := l2.Get(ctx, "19")
+	a.Equal(19, v)
+	a.Equal(true, ok)
+	_, ok, _ = l2.Get(ctx, "0")
+	a.Equal(false, ok)
+
+	// the queued writes are done by Close
+	a.Equal(nil, c.Set(ctx, "20", 20))
+	a.Equal(nil, c.Close())
+	v, ok, _ = l2.Get(ctx, "20")
+	a.Equal(20, v)
+	a.Equal(true, ok)
+	a.Equal(cache.ErrClosed, c.Flush(ctx))
+}
+
+func TestTieredCacheWriteBehindPending(t *testing.T) {
+	a := assert.NewAssert(t)
+	ctx := context.Background()
+	l2 := newCountingBackend()
+	l2.data["1"] = 1
+	c, err := cache.NewTieredCache[int](l2, cache.SetWriteMode(cache.WriteBehind))
+	a.Equal(nil, err)
+
+	// the writer is stalled, the reads see the writes in the queue instead of L2
+	l2.mu.Lock()
+	a.Equal(nil, c.Delete(ctx, "1"))
+	_, ok, err := c.Get(ctx, "1")
+	a.Equal(false, ok)
+	a.Equal(nil, err)
+	a.Equal(nil, c.Set(ctx, "2", 2))
+	c.L1().Delete("2")
+	v, ok, _ := c.Get(ctx, "2")
+	a.Equal(2, v)
+	a.Equal(true, ok)
+	a.Equal(int32(0), atomic.LoadInt32(&l2.reads))
+	l2.mu.Unlock()
+
+	a.Equal(nil, c.Flush(ctx))
+	_, ok = c.L1().Get("1")
+	a.Equal(false, ok)
+	_, ok = l2.get("1")
+	a.Equal(false, ok)
+	_, ok, _ = c.Get(ctx, "1")
+	a.Equal(false, ok)
+	a.Equal(int32(1), atomic.LoadInt32(&l2.reads))
+	a.Equal(nil, c.Close())
+}
+
+// gatedBackend a countingBackend whose reads wait until they are released or canceled
+type gatedBackend struct {
+	*countingBackend
+	started chan string
+	release chan struct{}
+}
+
+func newGatedBackend() *gatedBackend {
+	return &gatedBackend{countingBackend: newCountingBackend(), started: make(chan string, 8), release: make(chan struct{})}
+}
+
+func (b *gatedBackend) Get(ctx context.Context, key string) (int, bool, error) {
+	b.started <- key
+	select {
+	case <-b.release:
+		return b.countingBackend.Get(ctx, key)
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	}
+}
+
+func TestTieredCacheCanceledRead(t *testing.T) {
+	a := assert.NewAssert(t)
+	l2 := newGatedBackend()
+	l2.data["1"] = 1
+	c, err := cache.NewTieredCache[int](l2, cache.SetNegativeTTL(time.Minute))
+	a.Equal(nil, err)
+
+	// the caller waiting for the read of a canceled caller reads again, the cancellation is not cached
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get(ctx, "1")
+		first <- err
+	}()
+	<-l2.started
+	second := make(chan int, 1)
+	go func() {
+		v, _, err := c.Get(context.Background(), "1")
+		a.Equal(nil, err)
+		second <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	a.Equal(context.Canceled, <-first)
+	<-l2.started
+	close(l2.release)
+	a.Equal(1, <-second)
+	a.Equal(nil, c.Close())
+}
+
+func TestTieredCacheDeleteWhileReading(t *testing.T) {
+	a := assert.NewAssert(t)
+	ctx := context.Background()
+	l2 := newGatedBackend()
+	l2.data["1"] = 1
+	c, err := cache.NewTieredCache[int](l2)
+	a.Equal(nil, err)
+
+	// the data read from L2 before the delete is not promoted into L1
+	read := make(chan int, 1)
+	go func() {
+		v, _, _ := c.Get(ctx, "1")
+		read <- v
+	}()
+	<-l2.started
+	a.Equal(nil, c.Delete(ctx, "1"))
+	// the read has got the data before the delete
+	a.Equal(nil, l2.countingBackend.Set(ctx, "1", 1, 0))
+	close(l2.release)
+	a.Equal(1, <-read)
+	_, ok := c.L1().Get("1")
+	a.Equal(false, ok)
+	a.Equal(nil, c.Close())
+}
+
+func TestTieredCacheMap(t *testing.T) {
+	a := assert.NewAssert(t)
+	l2 := newCountingBackend()
+	c, err := cache.NewTieredCache[int](l2)
+	a.Equal(nil, err)
+	m := c.Map()
+
+	m.Set("1", 1)
+	m.SetMany(map[string]int{"2": 2, "3": 3}, 0)
+	v, _ := l2.get("3")
+	a.Equal(3, v)
+	c.L1().DeleteMany([]string{"1", "2", "3"})
+	v, ok := m.Get("1")
+	a.Equal(1, v)
+	a.Equal(true, ok)
+	a.Equal(map[string]int{"2": 2, "3": 3}, m.GetMany([]string{"2", "3", "4"}))
+
+	_, ok = m.Delete("1")
+	a.Equal(true, ok)
+	a.Equal(2, m.DeleteMany([]string{"2", "3"}))
+	for _, key := range []string{"1", "2", "3"} {
+		_, ok = l2.get(key)
+		a.Equal(false, ok)
+		_, ok = m.Get(key)
+		a.Equal(false, ok)
+	}
+
+	// L1 is not changed if writing L2 fails
+	l2.fail = errors.New("full")
+	m.Set("1", 1)
+	_, ok = m.Get("1")
+	a.Equal(false, ok)
+	a.Equal(nil, m.Close())
+	a.Equal(cache.ErrClosed, c.Close())
+}